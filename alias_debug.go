@@ -0,0 +1,79 @@
+//go:build customdebug
+
+package custom
+
+import (
+ "strconv"
+ "sync"
+ "unsafe"
+)
+
+// In a debug build each string returned by the Unsafe methods is remembered with a copy of its bytes, so that CheckAliases can tell whether the memory behind it has been written to since.
+// Only the most recent maxAliases are remembered, so that a long-running program does not keep every string alive.
+const maxAliases = 4096
+
+type alias struct {
+	s string
+	orig []byte
+}
+
+var aliasMu sync.Mutex
+var aliases []alias
+var aliasNext int
+
+// Returned by CheckAliases in a debug build when the memory behind a string returned by an Unsafe method has been written to
+type ErrAliasModified struct {
+	Was, Now string	// the string when it was read, and what it has since become
+}
+
+func (e *ErrAliasModified) Error() string {
+	return `Memory behind an unsafe string has been modified: was ` + strconv.Quote(e.Was) + `, now ` + strconv.Quote(e.Now)
+}
+
+// Returns a string which shares memory with b, remembering it for CheckAliases
+func aliasString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	a := alias{s: unsafe.String(&b[0], len(b)), orig: append([]byte(nil), b...)}
+	aliasMu.Lock()
+	if len(aliases) < maxAliases {
+		aliases = append(aliases, a)
+	} else {
+		aliases[aliasNext] = a
+		aliasNext = (aliasNext + 1) % maxAliases
+	}
+	aliasMu.Unlock()
+	return a.s
+}
+
+// Checks that the memory behind the strings returned by the Unsafe methods has not been written to, returning *ErrAliasModified for the first which has.
+func CheckAliases() error {
+	aliasMu.Lock()
+	defer aliasMu.Unlock()
+	for _, a := range aliases {
+		if a.s != string(a.orig) {
+			return &ErrAliasModified{Was: string(a.orig), Now: string([]byte(a.s))}
+		}
+	}
+	return nil
+}
+
+// Forgets the strings which share memory with region, so that CheckAliases does not read it after it has been unmapped
+func forgetAliases(region []byte) {
+	if len(region) == 0 {
+		return
+	}
+	start := uintptr(unsafe.Pointer(&region[0]))
+	end := start + uintptr(len(region))
+	aliasMu.Lock()
+	defer aliasMu.Unlock()
+	kept := make([]alias, 0, len(aliases))
+	for i := range aliases { // oldest first, so that the oldest are still the first to go
+		a := aliases[(aliasNext + i) % len(aliases)]
+		if p := uintptr(unsafe.Pointer(unsafe.StringData(a.s))); p < start || p >= end {
+			kept = append(kept, a)
+		}
+	}
+	aliases, aliasNext = kept, 0
+}
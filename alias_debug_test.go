@@ -0,0 +1,60 @@
+//go:build customdebug
+
+package custom
+
+import (
+	"testing"
+)
+
+// Forgets the strings remembered by other tests, and those remembered by this one once it ends
+func forgetAllAliases(t *testing.T) {
+	aliases, aliasNext = nil, 0
+	t.Cleanup(func() { aliases, aliasNext = nil, 0 })
+}
+
+func TestCheckAliases(t *testing.T) {
+	forgetAllAliases(t)
+	data := []byte("\x05hello\x05world")
+	r := NewBytesReader(data)
+	r.ReadString8Unsafe()
+	r.ReadString8Unsafe()
+	if err := CheckAliases(); err != nil {
+		t.Fatal(err)
+	}
+	data[7] = 'W'
+	e, ok := CheckAliases().(*ErrAliasModified)
+	if !ok || e.Was != `world` || e.Now != `World` {
+		t.Fatal(`expected *ErrAliasModified, got`, e)
+	}
+	data[7] = 'w'
+	forgetAliases(data[6:])
+	if len(aliases) != 1 || aliases[0].s != `hello` {
+		t.Fatal(`forgetAliases kept`, len(aliases), `strings`)
+	}
+	data[7] = 'W'
+	if err := CheckAliases(); err != nil {
+		t.Fatal(`a forgotten string was checked:`, err)
+	}
+}
+
+// Only the most recent strings are remembered
+func TestCheckAliasesLimit(t *testing.T) {
+	forgetAllAliases(t)
+	data := []byte("\x01a")
+	NewBytesReader(data).ReadString8Unsafe()
+	b := NewBuffer(0)
+	for i := 0; i < maxAliases; i++ {
+		b.WriteString8(`b`)
+	}
+	r := NewBytesReader(b.Bytes())
+	for i := 0; i < maxAliases; i++ {
+		r.ReadString8Unsafe()
+	}
+	if len(aliases) != maxAliases {
+		t.Fatal(`remembered`, len(aliases), `strings`)
+	}
+	data[1] = 'x'
+	if err := CheckAliases(); err != nil {
+		t.Fatal(`the oldest string was not forgotten:`, err)
+	}
+}
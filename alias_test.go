@@ -0,0 +1,42 @@
+package custom
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestUnsafeStrings(t *testing.T) {
+	b := NewBuffer(0)
+	b.WriteString8(`hello`)
+	b.WriteString16(`world`)
+	b.WriteString32(``)
+	b.WriteString32(strings.Repeat(`z`, 1000))
+	data := append([]byte(nil), b.Bytes()...)
+	r := NewBytesReader(data)
+	s8, s16, empty, s32 := r.ReadString8Unsafe(), r.ReadString16Unsafe(), r.ReadString32Unsafe(), r.ReadString32Unsafe()
+	if s8 != `hello` || s16 != `world` || empty != `` || s32 != strings.Repeat(`z`, 1000) || r.EOF() != nil {
+		t.Fatal(`read back the wrong strings`)
+	}
+	if err := CheckAliases(); err != nil {
+		t.Fatal(err)
+	}
+	data[1] = 'j' // the strings share memory with data
+	if s8 != `jello` {
+		t.Fatal(`the string was copied:`, s8)
+	}
+	data[1] = 'h'
+}
+
+func TestUnsafeStringsErrors(t *testing.T) {
+	if e := catchPanic(func() { NewBytesReader([]byte{5, 'a', 'b'}).ReadString8Unsafe() }); e != io.ErrUnexpectedEOF {
+		t.Fatal(`expected io.ErrUnexpectedEOF, got`, e)
+	}
+	b := NewBuffer(0)
+	b.WriteString32(strings.Repeat(`a`, 1000))
+	r := NewBytesReader(b.Bytes())
+	r.SetLimits(Limits{MaxStringLen: 100})
+	if e := limitViolation(func() { r.ReadString32Unsafe() }); e == nil || e.Limit != `MaxStringLen` {
+		t.Fatal(`expected a MaxStringLen violation, got`, e)
+	}
+}
@@ -0,0 +1,154 @@
+package custom
+
+import (
+ "errors"
+ "io"
+ "math/rand"
+ "os"
+ "path/filepath"
+ "strconv"
+)
+
+// Options for CreateAtomic
+type AtomicOptions struct {
+	Codec Codec	// the compression to write the file with, CodecNone for none
+	Sync bool	// fsync the file before it is renamed into place and the directory after, so that the new file survives a crash
+}
+
+var ErrNotAtomic = errors.New(`Not created by CreateAtomic`)
+
+// -------- ATOMIC FILE --------
+
+// Writes into a temporary file which is renamed over the destination only when closed without error
+type atomicFile struct {
+	f *os.File
+	w io.Writer	// the compressor writing into f, or f itself
+	filename string
+	sync bool
+	err error	// the first write error, which prevents the rename
+	done bool
+}
+
+// Creates a new buffered writer which writes into a temporary file in the same directory as filename, compressed with opts.Codec.
+// Close flushes the data, fsyncs if opts.Sync, and renames the temporary file to filename, so that filename is only ever the old file or the complete new one. If anything fails on the way the temporary file is removed.
+// Abort removes the temporary file instead, leaving filename untouched.
+// The file is created with perm less the umask, as os.Create and os.WriteFile would.
+func CreateAtomic(filename string, perm os.FileMode, opts AtomicOptions) (*Writer, error) {
+	if !opts.Codec.valid() {
+		return nil, ErrUnknownCodec
+	}
+	dir, base := filepath.Split(filename)
+	if dir == `` {
+		dir = `.`
+	}
+	f, err := createTemp(dir, `.` + base + `.tmp-`, perm)
+	if err != nil {
+		return nil, err
+	}
+	z, err := opts.Codec.newWriter(f)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	af := &atomicFile{f: f, w: z, filename: filename, sync: opts.Sync}
+	return &Writer{w: af, data: pool.Get().([]byte), close: true}, nil
+}
+
+// Creates a new file named prefix followed by a random number in dir, with perm less the umask. Unlike os.CreateTemp, which always uses 0600, the permissions are applied by the kernel as the file is created.
+func createTemp(dir string, prefix string, perm os.FileMode) (*os.File, error) {
+	for i := 0; ; i++ {
+		f, err := os.OpenFile(filepath.Join(dir, prefix + strconv.FormatUint(uint64(rand.Uint32()), 10)), os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) && i < 10000 {
+			continue
+		}
+		return f, err
+	}
+}
+
+func (af *atomicFile) Write(p []byte) (int, error) {
+	if af.err != nil {
+		return 0, af.err
+	}
+	n, err := af.w.Write(p)
+	if err != nil {
+		af.err = err
+	}
+	return n, err
+}
+
+// Finishes the compressed stream, then syncs, closes and renames the temporary file
+func (af *atomicFile) Close() error {
+	if af.done {
+		return nil
+	}
+	af.done = true
+	if c, ok := af.w.(io.Closer); ok && af.w != io.Writer(af.f) {
+		if err := c.Close(); err != nil && af.err == nil {
+			af.err = err
+		}
+	}
+	if af.err == nil && af.sync {
+		af.err = af.f.Sync()
+	}
+	if err := af.f.Close(); err != nil && af.err == nil {
+		af.err = err
+	}
+	if af.err == nil {
+		af.err = os.Rename(af.f.Name(), af.filename)
+	}
+	if af.err != nil {
+		os.Remove(af.f.Name())
+		return af.err
+	}
+	if af.sync { // the rename is only durable once the directory is synced
+		d, err := os.Open(filepath.Dir(af.filename))
+		if err != nil {
+			return err
+		}
+		err = d.Sync()
+		if cerr := d.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	return nil
+}
+
+// Flushes the compressor, if any
+func (af *atomicFile) Flush() error {
+	if f, ok := af.w.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (af *atomicFile) Sync() error {
+	return af.f.Sync()
+}
+
+// Closes and removes the temporary file
+func (af *atomicFile) abort() error {
+	if af.done {
+		return nil
+	}
+	af.done = true
+	if c, ok := af.w.(io.Closer); ok && af.w != io.Writer(af.f) { // releases the compressor's resources
+		c.Close()
+	}
+	af.f.Close()
+	return os.Remove(af.f.Name())
+}
+
+// Discards everything written to a custom.Writer created by CreateAtomic and removes the temporary file, leaving the destination as it was. Returns ErrNotAtomic for any other custom.Writer.
+func (w *Writer) Abort() error {
+	af, ok := w.w.(*atomicFile)
+	if !ok {
+		return ErrNotAtomic
+	}
+	w.cursor = 0
+	putBuffer(w.data)
+	w.data = nil
+	w.w = nil
+	return af.abort()
+}
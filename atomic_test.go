@@ -0,0 +1,126 @@
+package custom
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Returns the names in dir
+func atomicTestDir(t *testing.T, dir string) []string {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(ents))
+	for i, e := range ents {
+		names[i] = e.Name()
+	}
+	return names
+}
+
+func TestAtomicRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte(`new data `), 10000)
+	for _, codec := range []Codec{CodecNone, CodecZlib, CodecSnappy, CodecZstd} {
+		dir := t.TempDir()
+		filename := filepath.Join(dir, `file`)
+		os.WriteFile(filename, []byte(`old`), 0644)
+		w, err := CreateAtomic(filename, 0644, AtomicOptions{Codec: codec, Sync: true})
+		if err != nil {
+			t.Fatal(codec, err)
+		}
+		w.Write(payload)
+		w.Flush()
+		if b, _ := os.ReadFile(filename); string(b) != `old` {
+			t.Fatal(codec, `the destination changed before Close`)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(codec, err)
+		}
+		if names := atomicTestDir(t, dir); len(names) != 1 {
+			t.Fatal(codec, `left behind`, names)
+		}
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(codec, err)
+		}
+		z, err := codec.newReader(f)
+		if err != nil {
+			t.Fatal(codec, err)
+		}
+		if got, err := io.ReadAll(z); err != nil || !bytes.Equal(got, payload) {
+			t.Fatal(codec, `read back`, len(got), `bytes`, err)
+		}
+		f.Close()
+	}
+}
+
+// The file gets the same permissions as os.WriteFile gives, which applies the umask
+func TestAtomicPerm(t *testing.T) {
+	dir := t.TempDir()
+	for _, perm := range []os.FileMode{0600, 0640, 0666} {
+		os.WriteFile(filepath.Join(dir, `want`), nil, perm)
+		want, _ := os.Stat(filepath.Join(dir, `want`))
+		os.Remove(filepath.Join(dir, `want`))
+		w, err := CreateAtomic(filepath.Join(dir, `got`), perm, AtomicOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := os.Stat(filepath.Join(dir, `got`))
+		if got.Mode().Perm() != want.Mode().Perm() {
+			t.Fatalf(`perm %o: created with %o, os.WriteFile gives %o`, perm, got.Mode().Perm(), want.Mode().Perm())
+		}
+		os.Remove(filepath.Join(dir, `got`))
+	}
+}
+
+func TestAtomicAbort(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, `file`)
+	os.WriteFile(filename, []byte(`old`), 0644)
+	w, err := CreateAtomic(filename, 0644, AtomicOptions{Codec: CodecZstd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(bytes.Repeat([]byte(`discarded `), 10000))
+	if err = w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filename); string(b) != `old` {
+		t.Fatal(`Abort changed the destination`)
+	}
+	if names := atomicTestDir(t, dir); len(names) != 1 {
+		t.Fatal(`Abort left behind`, names)
+	}
+	if err = NewWriter(new(bytes.Buffer)).Abort(); err != ErrNotAtomic {
+		t.Fatal(`expected ErrNotAtomic, got`, err)
+	}
+}
+
+func TestAtomicErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := CreateAtomic(filepath.Join(dir, `file`), 0644, AtomicOptions{Codec: 99}); err != ErrUnknownCodec {
+		t.Fatal(`expected ErrUnknownCodec, got`, err)
+	}
+	if _, err := CreateAtomic(filepath.Join(dir, `missing`, `file`), 0644, AtomicOptions{}); err == nil {
+		t.Fatal(`created a file in a missing directory`)
+	}
+	// a failed rename removes the temporary file and leaves the destination alone
+	os.MkdirAll(filepath.Join(dir, `sub`, `full`), 0755)
+	w, err := CreateAtomic(filepath.Join(dir, `sub`), 0644, AtomicOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString(`data`)
+	if err = w.Close(); err == nil {
+		t.Fatal(`renamed a file over a directory`)
+	}
+	if names := atomicTestDir(t, dir); len(names) != 1 || names[0] != `sub` {
+		t.Fatal(`a failed Close left behind`, names)
+	}
+}
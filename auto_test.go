@@ -0,0 +1,86 @@
+package custom
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// Reads everything from an auto reader over data
+func readAuto(t *testing.T, data []byte) []byte {
+	start := data
+	if len(start) > 8 {
+		start = start[0:8]
+	}
+	r, err := NewAutoReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf(`NewAutoReader(%q): %v`, start, err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf(`reading %q: %v`, start, err)
+	}
+	return got
+}
+
+func TestAutoReaderFormats(t *testing.T) {
+	payload := bytes.Repeat([]byte(`the same words again and again, `), 1000)
+	formats := map[string]func(f io.Writer) *Writer{
+		`plain`: NewWriter,
+		`zlib`: NewZlibWriter,
+		`snappy`: NewSnappyWriter,
+		`zstd`: NewZstdWriter,
+		`gzip`: func(f io.Writer) *Writer { return NewWriterCloser(gzip.NewWriter(f)) },
+	}
+	for name, format := range formats {
+		var b bytes.Buffer
+		w := format(&b)
+		w.Write(payload)
+		if err := w.Close(); err != nil {
+			t.Fatal(name, err)
+		}
+		if name != `plain` && bytes.Equal(b.Bytes(), payload) {
+			t.Fatal(name, `was not compressed`)
+		}
+		if got := readAuto(t, b.Bytes()); !bytes.Equal(got, payload) {
+			t.Fatal(name, `read back`, len(got), `bytes`)
+		}
+	}
+}
+
+func TestAutoReaderShort(t *testing.T) {
+	for _, data := range []string{``, `a`, `x^`, `x^a`, "\x78\x9c", "\x1f", "\x28\xb5\x2f"} {
+		if got := readAuto(t, []byte(data)); string(got) != data {
+			t.Fatalf(`%q read back as %q`, data, got)
+		}
+	}
+}
+
+// Uncompressed data which begins like a Zlib header must still be read uncompressed
+func TestAutoReaderZlibNearMiss(t *testing.T) {
+	for _, data := range []string{
+		`x^ this text begins with a valid zlib header`,
+		`HKHK is a valid zlib header too`,
+		"\x78\xbb\x00\x00\x00\x01 has a preset dictionary",
+		"\x78\x9c\xff\xff\xff\xff\xff\xff\xff\xff",
+		"\x78\x01\x01\x05\x00\x00\x00hello", // a stored block whose length check fails
+	} {
+		if got := readAuto(t, []byte(data)); string(got) != data {
+			t.Fatalf(`%q read back as %q`, data, got)
+		}
+	}
+	rnd := rand.New(rand.NewSource(1))
+	headers := [][2]byte{{0x78, 0x01}, {0x78, 0x5e}, {0x78, 0x9c}, {0x78, 0xda}, {0x48, 0x4b}, {0x08, 0x1d}}
+	for i := 0; i < 2000; i++ {
+		data := make([]byte, 64 + rnd.Intn(1000))
+		rnd.Read(data)
+		h := headers[i % len(headers)]
+		data[0], data[1] = h[0], h[1]
+		if got := readAuto(t, data); !bytes.Equal(got, data) {
+			t.Fatalf(`random data beginning %x was read as Zlib`, data[0:8])
+		}
+	}
+}
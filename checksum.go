@@ -0,0 +1,215 @@
+package custom
+
+import (
+ "errors"
+ "hash/crc32"
+ "io"
+ "strconv"
+)
+
+// A checksummed stream begins with checksumMagic followed by the Checksum as 1 byte.
+// Each frame is then the payload length as a uint32, the payload, and the checksum of both the length and the payload (4 bytes for CRC32C, 8 bytes for xxHash64).
+// The stream ends with a frame with a payload length of 0, so that a truncated stream can be detected.
+const (
+	checksumMagic = "CSUM"
+	frameMaxLen = bufferLen
+)
+
+// The checksum used to verify each frame of a checksummed stream
+type Checksum uint8
+
+const (
+	ChecksumCRC32C Checksum = iota
+	ChecksumXXHash64
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+var ErrUnknownChecksum = errors.New(`Unknown checksum`)
+
+// Returned (or panicked, for methods without an error) when a frame of a checksummed stream is corrupt
+type ErrChecksumMismatch struct {
+	Offset int64	// the offset in the underlying stream at which the corrupt frame begins
+}
+
+func (e *ErrChecksumMismatch) Error() string {
+	return `Checksum mismatch in frame at offset ` + strconv.FormatInt(e.Offset, 10)
+}
+
+func (c Checksum) size() int {
+	if c == ChecksumXXHash64 {
+		return 8
+	}
+	return 4
+}
+
+func (c Checksum) compute(b []byte) uint64 {
+	if c == ChecksumXXHash64 {
+		return xxhash64(b)
+	}
+	return uint64(crc32.Checksum(b, crc32c))
+}
+
+// Appends the checksum of b to b
+func (c Checksum) sum(b []byte) []byte {
+	v := c.compute(b)
+	if c == ChecksumXXHash64 {
+		return append(b, byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24), byte(v >> 32), byte(v >> 40), byte(v >> 48), byte(v >> 56))
+	}
+	return append(b, byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24))
+}
+
+// -------- CHECKSUM WRITER --------
+
+type checksumWriter struct {
+	w io.Writer
+	algo Checksum
+	frame []byte
+	header bool
+}
+
+// Creates a new buffered writer wrapping an io.Writer, which splits the output into frames that each carry a checksum so that corruption is detected by NewChecksumReader.
+// Each time the buffer is flushed it is written as one frame. The custom.Writer must be closed to end the stream.
+func NewChecksumWriter(f io.Writer, algo Checksum) *Writer {
+	if algo > ChecksumXXHash64 {
+		panic(ErrUnknownChecksum)
+	}
+	cw := &checksumWriter{w: f, algo: algo, frame: make([]byte, 0, 4 + frameMaxLen + 8)}
+	return &Writer{w: cw, data: pool.Get().([]byte), close: true, under: f}
+}
+
+func (cw *checksumWriter) writeFrame(p []byte) error {
+	if !cw.header {
+		cw.header = true
+		if _, err := cw.w.Write(append([]byte(checksumMagic), byte(cw.algo))); err != nil {
+			return err
+		}
+	}
+	l := len(p)
+	frame := append(cw.frame[0:0], byte(l), byte(l >> 8), byte(l >> 16), byte(l >> 24))
+	frame = cw.algo.sum(append(frame, p...))
+	_, err := cw.w.Write(frame)
+	return err
+}
+
+func (cw *checksumWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		l := len(p)
+		if l > frameMaxLen {
+			l = frameMaxLen
+		}
+		if err = cw.writeFrame(p[0:l]); err != nil {
+			return
+		}
+		p = p[l:]
+		n += l
+	}
+	return
+}
+
+// Writes the end of stream frame. The underlying io.Writer is not closed.
+func (cw *checksumWriter) Close() error {
+	return cw.writeFrame(nil)
+}
+
+// -------- CHECKSUM READER --------
+
+type checksumReader struct {
+	r io.Reader
+	algo Checksum
+	frame []byte
+	payload []byte	// the unread part of the current frame
+	offset int64	// the offset of the next frame in the underlying stream
+	err error
+}
+
+// Creates a new buffered reader wrapping an io.Reader which contains a stream written by NewChecksumWriter. Each frame is verified before any of it is read.
+// A corrupt frame causes reads to fail with *ErrChecksumMismatch. Panics if the stream header is invalid.
+func NewChecksumReader(f io.Reader) *Reader {
+	r, err := OpenChecksumReader(f)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Creates a new buffered reader wrapping an io.Reader which contains a stream written by NewChecksumWriter. Each frame is verified before any of it is read.
+// A corrupt frame causes reads to fail with *ErrChecksumMismatch. Returns an error if the stream header is invalid.
+func OpenChecksumReader(f io.Reader) (*Reader, error) {
+	cr, err := newChecksumReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{f: cr, buf: pool.Get().([]byte)}, nil
+}
+
+func newChecksumReader(f io.Reader) (*checksumReader, error) {
+	var header [len(checksumMagic) + 1]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	algo := Checksum(header[len(checksumMagic)])
+	if string(header[0:len(checksumMagic)]) != checksumMagic || algo > ChecksumXXHash64 {
+		return nil, ErrInvalidHeader
+	}
+	return &checksumReader{r: f, algo: algo, frame: make([]byte, 4 + frameMaxLen + 8), offset: int64(len(header))}, nil
+}
+
+// Reads the header of the stream in f and starts verifying it from there
+func (cr *checksumReader) reset(f io.Reader) (io.Reader, error) {
+	ncr, err := newChecksumReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return ncr, nil
+}
+
+// Reads and verifies the next frame
+func (cr *checksumReader) next() error {
+	if _, err := io.ReadFull(cr.r, cr.frame[0:4]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	l := int(uint32(cr.frame[0]) | uint32(cr.frame[1])<<8 | uint32(cr.frame[2])<<16 | uint32(cr.frame[3])<<24)
+	if l > frameMaxLen {
+		return &ErrChecksumMismatch{Offset: cr.offset}
+	}
+	size := cr.algo.size()
+	frame := cr.frame[0:4 + l + size]
+	if _, err := io.ReadFull(cr.r, frame[4:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	var stored uint64
+	for i, c := range frame[4 + l:] {
+		stored |= uint64(c) << (8 * uint(i))
+	}
+	if cr.algo.compute(frame[0:4 + l]) != stored {
+		return &ErrChecksumMismatch{Offset: cr.offset}
+	}
+	cr.offset += int64(len(frame))
+	if l == 0 {
+		return io.EOF
+	}
+	cr.payload = frame[4:4 + l]
+	return nil
+}
+
+func (cr *checksumReader) Read(p []byte) (int, error) {
+	for len(cr.payload) == 0 {
+		if cr.err != nil {
+			return 0, cr.err
+		}
+		cr.err = cr.next()
+	}
+	n := copy(p, cr.payload)
+	cr.payload = cr.payload[n:]
+	return n, nil
+}
@@ -0,0 +1,150 @@
+package custom
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// Writes strings and a large block of zeros as a checksummed stream
+func checksumTestStream(t *testing.T, algo Checksum) []byte {
+	var b bytes.Buffer
+	w := NewChecksumWriter(&b, algo)
+	for i := 0; i < 50000; i++ {
+		w.WriteString32(`hello`)
+	}
+	w.Write(make([]byte, 200000))
+	if err := w.Close(); err != nil {
+		t.Fatal(algo, err)
+	}
+	return b.Bytes()
+}
+
+// Returns the offsets at which each frame of a checksummed stream begins
+func checksumTestFrames(data []byte, algo Checksum) []int64 {
+	var frames []int64
+	for at := len(checksumMagic) + 1; at < len(data); {
+		frames = append(frames, int64(at))
+		at += 4 + int(uint32(data[at]) | uint32(data[at + 1])<<8 | uint32(data[at + 2])<<16 | uint32(data[at + 3])<<24) + algo.size()
+	}
+	return frames
+}
+
+// Reads until an error, returning it
+func readChecksum(data []byte) error {
+	r, err := OpenChecksumReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.ReadAll(r)
+	return err
+}
+
+func TestChecksumRoundTrip(t *testing.T) {
+	for _, algo := range []Checksum{ChecksumCRC32C, ChecksumXXHash64} {
+		data := checksumTestStream(t, algo)
+		if string(data[0:len(checksumMagic)]) != checksumMagic || Checksum(data[len(checksumMagic)]) != algo {
+			t.Fatal(algo, `invalid stream header`)
+		}
+		r, err := OpenChecksumReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(algo, err)
+		}
+		for i := 0; i < 50000; i++ {
+			if s := r.ReadString32(); s != `hello` {
+				t.Fatalf(`algo %d: string %d read as %q`, algo, i, s)
+			}
+		}
+		if !bytes.Equal(r.Readx(200000), make([]byte, 200000)) {
+			t.Fatal(algo, `read back the wrong data`)
+		}
+		if err = r.EOF(); err != nil {
+			t.Fatal(algo, err)
+		}
+		r.Close()
+		// an empty stream is just the header and the end frame
+		var b bytes.Buffer
+		NewChecksumWriter(&b, algo).Close()
+		if b.Len() != len(checksumMagic) + 1 + 4 + algo.size() || readChecksum(b.Bytes()) != nil {
+			t.Fatal(algo, `an empty stream is`, b.Len(), `bytes`)
+		}
+	}
+}
+
+// Corruption anywhere in a frame is reported with the offset of that frame
+func TestChecksumCorrupt(t *testing.T) {
+	for _, algo := range []Checksum{ChecksumCRC32C, ChecksumXXHash64} {
+		data := checksumTestStream(t, algo)
+		frames := checksumTestFrames(data, algo)
+		for _, at := range []int{int(frames[0]), int(frames[0]) + 4, 100000, len(data) / 2, len(data) - 1} {
+			bad := append([]byte(nil), data...)
+			bad[at] ^= 4
+			e, ok := readChecksum(bad).(*ErrChecksumMismatch)
+			if !ok {
+				t.Fatalf(`algo %d: corruption at %d was not detected`, algo, at)
+			}
+			i := len(frames) - 1
+			for frames[i] > int64(at) {
+				i--
+			}
+			if e.Offset != frames[i] {
+				t.Fatalf(`algo %d: corruption at %d reported at %d, not %d`, algo, at, e.Offset, frames[i])
+			}
+		}
+		// a length longer than any frame
+		bad := append([]byte(nil), data...)
+		bad[frames[1] + 3] = 0xff
+		if _, ok := readChecksum(bad).(*ErrChecksumMismatch); !ok {
+			t.Fatal(algo, `an impossible frame length was not detected`)
+		}
+	}
+}
+
+func TestChecksumTruncated(t *testing.T) {
+	data := checksumTestStream(t, ChecksumCRC32C)
+	frames := checksumTestFrames(data, ChecksumCRC32C)
+	for _, n := range []int{int(frames[0]), int(frames[0]) + 2, int(frames[1]) - 1, int(frames[len(frames) - 1]), len(data) - 1} {
+		if err := readChecksum(data[0:n]); err != io.ErrUnexpectedEOF {
+			t.Fatalf(`truncated to %d bytes: expected io.ErrUnexpectedEOF, got %v`, n, err)
+		}
+	}
+}
+
+func TestChecksumHeader(t *testing.T) {
+	data := checksumTestStream(t, ChecksumXXHash64)
+	for _, c := range []struct {
+		name string
+		data []byte
+		err error
+	}{
+		{`empty`, nil, io.ErrUnexpectedEOF},
+		{`short`, data[0:3], io.ErrUnexpectedEOF},
+		{`magic`, append([]byte(`CSUX`), data[len(checksumMagic):]...), ErrInvalidHeader},
+		{`algorithm`, append([]byte("CSUM\x07"), data[len(checksumMagic) + 1:]...), ErrInvalidHeader},
+	} {
+		if _, err := OpenChecksumReader(bytes.NewReader(c.data)); err != c.err {
+			t.Fatalf(`%s: expected %v, got %v`, c.name, c.err, err)
+		}
+	}
+	if e := catchPanic(func() { NewChecksumWriter(io.Discard, 7) }); e != ErrUnknownChecksum {
+		t.Fatal(`expected ErrUnknownChecksum, got`, e)
+	}
+}
+
+// Known xxHash64 values with a seed of 0
+func TestXXHash64(t *testing.T) {
+	for _, c := range []struct {
+		in string
+		sum uint64
+	}{
+		{``, 0xef46db3751d8e999},
+		{`a`, 0xd24ec4f1a98c6e5b},
+		{`abc`, 0x44bc2cf5ad770999},
+		{`Nobody inspects the spammish repetition`, 0xfbcea83c8a378bf1},
+	} {
+		if sum := xxhash64([]byte(c.in)); sum != c.sum {
+			t.Fatalf(`xxhash64(%q) is %x, not %x`, c.in, sum, c.sum)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package custom
+
+import (
+ "bytes"
+ "errors"
+ "io"
+ "sync"
+ "github.com/klauspost/compress/zlib"
+ "github.com/klauspost/compress/zstd"
+ "github.com/AlasdairF/snappy"
+)
+
+// -------- CODECS --------
+
+// The compression format used for each block of a block compressed stream
+type Codec uint8
+
+const (
+	CodecNone Codec = iota
+	CodecZlib
+	CodecSnappy
+	CodecZstd
+)
+
+var ErrUnknownCodec = errors.New(`Unknown codec`)
+var ErrCorruptBlock = errors.New(`Corrupt block`)
+
+var zlibWriters = sync.Pool{
+	New: func() interface{} {
+		return zlib.NewWriter(nil)
+	},
+}
+
+var zlibReaders sync.Pool
+
+var zstdOnce sync.Once
+var zstdEncoder *zstd.Encoder
+var zstdDecoder *zstd.Decoder
+
+// The zstd encoder and decoder are safe for concurrent use with EncodeAll and DecodeAll, so one of each is shared by all blocks
+func zstdCoders() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		var err error
+		if zstdEncoder, err = zstd.NewWriter(nil); err != nil {
+			panic(err)
+		}
+		if zstdDecoder, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0)); err != nil {
+			panic(err)
+		}
+	})
+	return zstdEncoder, zstdDecoder
+}
+
+func (c Codec) valid() bool {
+	return c <= CodecZstd
+}
+
+// Compresses src as one independent block, appending it to dst
+func (c Codec) compress(dst, src []byte) ([]byte, error) {
+	switch c {
+		case CodecNone:
+			return append(dst, src...), nil
+		case CodecZlib:
+			buf := bytes.NewBuffer(dst)
+			z := zlibWriters.Get().(*zlib.Writer)
+			defer zlibWriters.Put(z)
+			z.Reset(buf)
+			if _, err := z.Write(src); err != nil {
+				return dst, err
+			}
+			if err := z.Close(); err != nil {
+				return dst, err
+			}
+			return buf.Bytes(), nil
+		case CodecSnappy:
+			n := len(dst)
+			need := n + snappy.MaxEncodedLen(len(src))
+			if cap(dst) < need {
+				grown := make([]byte, n, need)
+				copy(grown, dst)
+				dst = grown
+			}
+			enc := snappy.Encode(dst[n:need], src)
+			return dst[0:n+len(enc)], nil
+		case CodecZstd:
+			enc, _ := zstdCoders()
+			return enc.EncodeAll(src, dst), nil
+	}
+	return dst, ErrUnknownCodec
+}
+
+// Decompresses a block compressed with compress into dst, which must be exactly the length of the uncompressed data
+func (c Codec) decompress(dst, src []byte) error {
+	switch c {
+		case CodecNone:
+			if len(src) != len(dst) {
+				return ErrCorruptBlock
+			}
+			copy(dst, src)
+			return nil
+		case CodecZlib:
+			var z io.ReadCloser
+			var err error
+			if zr, ok := zlibReaders.Get().(io.ReadCloser); ok {
+				z = zr
+				err = zr.(zlib.Resetter).Reset(bytes.NewReader(src), nil)
+			} else {
+				z, err = zlib.NewReader(bytes.NewReader(src))
+			}
+			if err != nil {
+				return err
+			}
+			defer zlibReaders.Put(z)
+			if _, err = io.ReadFull(z, dst); err != nil {
+				return ErrCorruptBlock
+			}
+			return nil
+		case CodecSnappy:
+			if n, err := snappy.DecodedLen(src); err != nil || n != len(dst) {
+				return ErrCorruptBlock
+			}
+			if _, err := snappy.Decode(dst, src); err != nil {
+				return err
+			}
+			return nil
+		case CodecZstd:
+			_, dec := zstdCoders()
+			res, err := dec.DecodeAll(src, dst[0:0])
+			if err != nil {
+				return err
+			}
+			if len(res) != len(dst) || (len(res) > 0 && &res[0] != &dst[0]) {
+				return ErrCorruptBlock
+			}
+			return nil
+	}
+	return ErrUnknownCodec
+}
+
+// Wraps w with a streaming compressor for the codec. The compressor must be closed to finish the stream.
+func (c Codec) newWriter(w io.Writer) (io.Writer, error) {
+	switch c {
+		case CodecNone:
+			return w, nil
+		case CodecZlib:
+			return zlib.NewWriter(w), nil
+		case CodecSnappy:
+			return snappy.NewWriter(w), nil
+		case CodecZstd:
+			return zstd.NewWriter(w)
+	}
+	return nil, ErrUnknownCodec
+}
+
+// Wraps r with a streaming decompressor for the codec
+func (c Codec) newReader(r io.Reader) (io.Reader, error) {
+	switch c {
+		case CodecNone:
+			return r, nil
+		case CodecZlib:
+			return zlib.NewReader(r)
+		case CodecSnappy:
+			return snappy.NewReader(r), nil
+		case CodecZstd:
+			z, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return zstdReader{z}, nil
+	}
+	return nil, ErrUnknownCodec
+}
+
+// A stack of writers, each writing into the next. Writes go to the first and Close closes each in turn, so that every layer finishes its stream.
+type writeChain []io.Writer
+
+func (wc writeChain) Write(p []byte) (int, error) {
+	return wc[0].Write(p)
+}
+
+// Flushes each layer in turn, so that everything written has reached the last
+func (wc writeChain) Flush() error {
+	for _, w := range wc {
+		if f, ok := w.(flusher); ok {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (wc writeChain) Close() (err error) {
+	for _, w := range wc {
+		if c, ok := w.(io.Closer); ok {
+			if cerr := c.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,72 @@
+package custom
+
+import (
+ "context"
+ "io"
+ "time"
+)
+
+// Options for CopyContext. Zero fields are ignored.
+type CopyOptions struct {
+	Progress func(copied int64)	// called after each write with the total copied so far
+	RateLimit int64	// the most bytes to copy per second
+	MaxBytes int64	// the most bytes to copy, as with io.CopyN except that it is not an error if r ends first
+}
+
+// Copies from r to w until EOF, like Copy but through a pooled buffer only, stopping with ctx.Err() if ctx is cancelled. Returns the number of bytes copied, and io.ErrShortWrite if w accepts fewer bytes than it was given without an error.
+// The context is checked between reads, so a Read that blocks (e.g. on a network connection) must be ended by its own deadline or by closing r.
+func CopyContext(ctx context.Context, w io.Writer, r io.Reader, opts CopyOptions) (t int64, err error) {
+	b := pool.Get().([]byte)
+	defer pool.Put(b)
+	chunk := int64(len(b))
+	if opts.RateLimit > 0 && opts.RateLimit < chunk { // read no more than a second's worth at once, so the rate stays smooth
+		chunk = opts.RateLimit
+	}
+	start := time.Now()
+	var m int
+	for {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		l := chunk
+		if opts.MaxBytes > 0 {
+			if left := opts.MaxBytes - t; left < l {
+				if left <= 0 {
+					return
+				}
+				l = left
+			}
+		}
+		m, err = r.Read(b[0:l])
+		if m > 0 {
+			nw, werr := w.Write(b[0:m])
+			t += int64(nw)
+			if werr == nil && nw < m {
+				werr = io.ErrShortWrite
+			}
+			if werr != nil {
+				return t, werr
+			}
+			if opts.Progress != nil {
+				opts.Progress(t)
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		if opts.RateLimit > 0 { // wait until the time by which t bytes should have been copied
+			if wait := time.Duration(float64(t) / float64(opts.RateLimit) * float64(time.Second)) - time.Since(start); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+					case <-ctx.Done():
+						timer.Stop()
+						return t, ctx.Err()
+					case <-timer.C:
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,27 @@
+//go:build linux
+
+package custom
+
+import (
+ "io"
+ "net"
+ "os"
+)
+
+// Copies directly between files and TCP connections, leaving the kernel to use copy_file_range, sendfile or splice. Returns false if the ends are not ones it can copy between.
+func zeroCopy(w io.Writer, r io.Reader) (int64, bool, error) {
+	switch r.(type) {
+		case *os.File, *net.TCPConn:
+		default:
+			return 0, false, nil
+	}
+	switch dst := w.(type) {
+		case *os.File:
+			t, err := dst.ReadFrom(r)
+			return t, true, err
+		case *net.TCPConn:
+			t, err := dst.ReadFrom(r)
+			return t, true, err
+	}
+	return 0, false, nil
+}
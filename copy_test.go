@@ -0,0 +1,199 @@
+package custom
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Accepts at most max bytes from each Write, without an error
+type shortWriter struct {
+	bytes.Buffer
+	max int
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if len(p) > w.max {
+		p = p[0:w.max]
+	}
+	return w.Buffer.Write(p)
+}
+
+// Fails every Write after the first n bytes
+type failingWriter struct {
+	n int
+}
+
+var errTestWrite = errors.New(`write failed`)
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		m := w.n
+		w.n = 0
+		return m, errTestWrite
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestCopy(t *testing.T) {
+	dir := t.TempDir()
+	data := sectionTestData(500000)
+	src := filepath.Join(dir, `src`)
+	os.WriteFile(src, data, 0644)
+	// file to file, from part way through the source
+	in, _ := os.Open(src)
+	defer in.Close()
+	in.Seek(1000, io.SeekStart)
+	out, _ := os.Create(filepath.Join(dir, `dst`))
+	out.WriteString(`header`)
+	n, err := Copy(out, in)
+	out.Close()
+	if err != nil || n != int64(len(data) - 1000) {
+		t.Fatal(`copied`, n, err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, `dst`)); string(b[0:6]) != `header` || !bytes.Equal(b[6:], data[1000:]) {
+		t.Fatal(`the copied file is wrong`)
+	}
+	// through the buffer
+	var b bytes.Buffer
+	if n, err = Copy(&b, io.MultiReader(bytes.NewReader(data))); err != nil || n != int64(len(data)) || !bytes.Equal(b.Bytes(), data) {
+		t.Fatal(`copied`, n, err)
+	}
+	b.Reset()
+	if n, err = CopyFile(&b, src); err != nil || n != int64(len(data)) || !bytes.Equal(b.Bytes(), data) {
+		t.Fatal(`CopyFile copied`, n, err)
+	}
+	b.Reset()
+	if n, err = Copy(&b, bytes.NewReader(nil)); err != nil || n != 0 {
+		t.Fatal(`copied`, n, err)
+	}
+}
+
+// A file sent over a TCP connection, which Linux does with sendfile
+func TestCopyFileTCP(t *testing.T) {
+	l, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Skip(`cannot listen:`, err)
+	}
+	defer l.Close()
+	data := sectionTestData(300000)
+	src := filepath.Join(t.TempDir(), `src`)
+	os.WriteFile(src, data, 0644)
+	received := make(chan []byte)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		var b bytes.Buffer
+		Copy(&b, c)
+		c.Close()
+		received <- b.Bytes()
+	}()
+	c, err := net.Dial(`tcp`, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := CopyFile(c, src)
+	c.Close()
+	if err != nil || n != int64(len(data)) {
+		t.Fatal(`copied`, n, err)
+	}
+	if got := <-received; !bytes.Equal(got, data) {
+		t.Fatal(`received`, len(got), `bytes`)
+	}
+}
+
+func TestCopyErrors(t *testing.T) {
+	if _, err := CopyFile(io.Discard, filepath.Join(t.TempDir(), `missing`)); !os.IsNotExist(err) {
+		t.Fatal(`expected a not exist error, got`, err)
+	}
+	n, err := Copy(&failingWriter{n: 1500}, io.MultiReader(bytes.NewReader(sectionTestData(300000))))
+	if err != errTestWrite {
+		t.Fatal(`expected the write error, got`, n, err)
+	}
+	n, err = Copy(io.Discard, io.MultiReader(bytes.NewReader(sectionTestData(100)), failingReader{}))
+	if err != errTestRead {
+		t.Fatal(`expected the read error, got`, n, err)
+	}
+}
+
+func TestCopyContext(t *testing.T) {
+	data := sectionTestData(300000)
+	var b bytes.Buffer
+	var last int64
+	n, err := CopyContext(context.Background(), &b, bytes.NewReader(data), CopyOptions{Progress: func(copied int64) { last = copied }})
+	if err != nil || n != int64(len(data)) || last != n || !bytes.Equal(b.Bytes(), data) {
+		t.Fatal(`copied`, n, err)
+	}
+	b.Reset()
+	n, err = CopyContext(context.Background(), &b, bytes.NewReader(data), CopyOptions{MaxBytes: 100001})
+	if err != nil || n != 100001 || !bytes.Equal(b.Bytes(), data[0:100001]) {
+		t.Fatal(`copied`, n, err)
+	}
+	b.Reset()
+	n, err = CopyContext(context.Background(), &b, bytes.NewReader(nil), CopyOptions{})
+	if err != nil || n != 0 || b.Len() != 0 {
+		t.Fatal(`copied`, n, err)
+	}
+}
+
+func TestCopyContextShortWrite(t *testing.T) {
+	w := &shortWriter{max: 1000}
+	n, err := CopyContext(context.Background(), w, bytes.NewReader(sectionTestData(5000)), CopyOptions{})
+	if err != io.ErrShortWrite || n != 1000 || w.Len() != 1000 {
+		t.Fatal(`expected io.ErrShortWrite after 1000 bytes, got`, n, err)
+	}
+}
+
+func TestCopyContextErrors(t *testing.T) {
+	n, err := CopyContext(context.Background(), &failingWriter{n: 1500}, bytes.NewReader(sectionTestData(300000)), CopyOptions{})
+	if err != errTestWrite || n != 1500 {
+		t.Fatal(`expected the write error after 1500 bytes, got`, n, err)
+	}
+	r := io.MultiReader(bytes.NewReader(sectionTestData(100)), failingReader{})
+	n, err = CopyContext(context.Background(), io.Discard, r, CopyOptions{})
+	if err != errTestRead || n != 100 {
+		t.Fatal(`expected the read error after 100 bytes, got`, n, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n, err = CopyContext(ctx, io.Discard, bytes.NewReader(sectionTestData(100)), CopyOptions{})
+	if err != context.Canceled || n != 0 {
+		t.Fatal(`expected context.Canceled, got`, n, err)
+	}
+}
+
+func TestCopyContextRateLimit(t *testing.T) {
+	start := time.Now()
+	n, err := CopyContext(context.Background(), io.Discard, bytes.NewReader(sectionTestData(30000)), CopyOptions{RateLimit: 100000})
+	if err != nil || n != 30000 {
+		t.Fatal(`copied`, n, err)
+	}
+	if d := time.Since(start); d < 250 * time.Millisecond {
+		t.Fatal(`30000 bytes at 100000 per second took`, d)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+	defer cancel()
+	n, err = CopyContext(ctx, io.Discard, bytes.NewReader(sectionTestData(300000)), CopyOptions{RateLimit: 10000})
+	if err != context.DeadlineExceeded || n >= 300000 {
+		t.Fatal(`expected context.DeadlineExceeded, got`, n, err)
+	}
+}
+
+// Always fails
+type failingReader struct{}
+
+var errTestRead = errors.New(`read failed`)
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errTestRead
+}
@@ -4,25 +4,23 @@ import (
  "github.com/AlasdairF/Conv"
  "unicode/utf8"
  "math"
+ "math/bits"
  "io"
  "os"
  "errors"
+ "hash"
  "reflect"
  "sync"
+ "time"
+ "bytes"
  "github.com/klauspost/compress/zlib"
+ "github.com/klauspost/compress/gzip"
+ "github.com/klauspost/compress/zstd"
  "github.com/AlasdairF/snappy"
 )
 
 const (
 	bufferLen = 65536 // determined in trials on writing to disk and writing to memory
-	bufferLenMinus1  = bufferLen - 1
-	bufferLenMinus2  = bufferLen - 2
-	bufferLenMinus3  = bufferLen - 3
-	bufferLenMinus4  = bufferLen - 4
-	bufferLenMinus5  = bufferLen - 5
-	bufferLenMinus6  = bufferLen - 6
-	bufferLenMinus7  = bufferLen - 7
-	bufferLenMinus8  = bufferLen - 8
 	bufferLenMinus512 = bufferLen - 512
 )
 
@@ -47,6 +45,13 @@ const (
 )
 
 var ErrNotEOF = errors.New(`Not EOF`)
+var ErrInvalidHeader = errors.New(`Invalid header`)
+var ErrPeekTooLarge = errors.New(`Peek is larger than the buffer`)
+var ErrUnread = errors.New(`Cannot unread: the data is no longer in the buffer`)
+var ErrResetUnsupported = errors.New(`This reader cannot be Reset`)
+
+// The stream identifier which begins every Snappy framed stream
+const snappyMagic = "\xff\x06\x00\x00sNaPpY"
 
 // -------- INTERFACE --------
 
@@ -91,48 +96,75 @@ type Interface interface {
 
 // -------- POOL -------
 
-var pool = sync.Pool{
-    New: func() interface{} {
-        return make([]byte, bufferLen)
-    },
+// Buffers are pooled in power-of-two size classes from minBufferLen to maxBufferLen. Larger buffers are allocated to size and left to the garbage collector.
+const (
+	minBufferShift = 9
+	maxBufferShift = 24
+	minBufferLen = 1 << minBufferShift // 512 bytes, so that everything written or read in one go fits
+	maxBufferLen = 1 << maxBufferShift // 16 MiB
+)
+
+var pools [maxBufferShift - minBufferShift + 1]sync.Pool
+
+// The pool of buffers of the default size, bufferLen
+var pool = &pools[bufferClass(bufferLen)]
+
+func init() {
+	for i := range pools {
+		size := minBufferLen << i
+		pools[i].New = func() interface{} {
+			return make([]byte, size)
+		}
+	}
+}
+
+// Returns the size class of the smallest pooled buffers of at least n bytes
+func bufferClass(n int) int {
+	if n <= minBufferLen {
+		return 0
+	}
+	return bits.Len(uint(n - 1)) - minBufferShift
+}
+
+// Returns a buffer of at least n bytes, rounded up to a power of two (and at least minBufferLen), from the pool of that size
+func getBuffer(n int) []byte {
+	if n > maxBufferLen {
+		return make([]byte, n)
+	}
+	return pools[bufferClass(n)].Get().([]byte)
+}
+
+// Returns a buffer to the pool of its size. Buffers which are not one of the pooled sizes are left to the garbage collector.
+func putBuffer(b []byte) {
+	c := cap(b)
+	if c < minBufferLen || c > maxBufferLen || c & (c - 1) != 0 {
+		return
+	}
+	pools[bufferClass(c)].Put(b[0:c])
 }
 
 // -------- COPY --------
 
-func Copy(w io.Writer, r io.Reader) (t int, err error) {
-	b := pool.Get().([]byte)
-	defer pool.Put(b)
-	var m, n int
-	for {
-		m, err = r.Read(b[n:])
-		n += m
-		if err == io.EOF {
-			_, err = w.Write(b[0:n])
-			t += n
-			return
-		}
-		if err != nil {
-			t += n
-			return
-		}
-		if n >= bufferLenMinus512 {
-			_, err = w.Write(b[0:n])
-			t += n
-			if err != nil {
-				return
-			}
-			n = 0
-		}
+// Copies from r to w until EOF, returning the number of bytes copied. When both ends are files or TCP connections on Linux, the kernel copies directly between them (copy_file_range, sendfile or splice); otherwise a pooled buffer is used.
+func Copy(w io.Writer, r io.Reader) (int64, error) {
+	if t, ok, err := zeroCopy(w, r); ok {
+		return t, err
 	}
+	return copyBuffer(w, r)
 }
 
-func CopyFile(w io.Writer, filename string) (t int, err error) {
-	var r *os.File
-	r, err = os.Open(filename)
+// Copies the contents of a file to w, returning the number of bytes copied. See Copy.
+func CopyFile(w io.Writer, filename string) (int64, error) {
+	r, err := os.Open(filename)
 	if err != nil {
-		return
+		return 0, err
 	}
 	defer r.Close()
+	return Copy(w, r)
+}
+
+// Copies from r to w through a pooled buffer
+func copyBuffer(w io.Writer, r io.Reader) (t int64, err error) {
 	b := pool.Get().([]byte)
 	defer pool.Put(b)
 	var m, n int
@@ -141,16 +173,16 @@ func CopyFile(w io.Writer, filename string) (t int, err error) {
 		n += m
 		if err == io.EOF {
 			_, err = w.Write(b[0:n])
-			t += n
+			t += int64(n)
 			return
 		}
 		if err != nil {
-			t += n
+			t += int64(n)
 			return
 		}
 		if n >= bufferLenMinus512 {
 			_, err = w.Write(b[0:n])
-			t += n
+			t += int64(n)
 			if err != nil {
 				return
 			}
@@ -165,7 +197,13 @@ type Writer struct {
 	w io.Writer
 	data []byte
 	cursor int
-	close bool
+	offset int64	// how much has been written to the underlying io.Writer, i.e. the position of data[0]
+	close, seeked bool
+	h hash.Hash	// the hash of a hashing writer
+	under io.Writer	// the io.Writer beneath a compressor or other layer, which is what Sync syncs
+	policy *SyncPolicy	// set with SetSyncPolicy
+	unsynced int64	// how much has been written since the last sync
+	synced time.Time	// when the last sync was
 }
 
 // Creates a new buffered writer wrapping an io.Writer
@@ -177,6 +215,11 @@ func NewWriter(f io.Writer) *Writer {
 	}
 }
 
+// Creates a new buffered writer wrapping an io.Writer with a buffer of at least size bytes, rounded up to a power of two. Smaller buffers save memory with many connections, larger ones can be faster for bulk I/O.
+func NewWriterSize(f io.Writer, size int) *Writer {
+	return &Writer{w: f, data: getBuffer(size)}
+}
+
 // Creates a new buffered writer wrapping an io.Writer which attempts to close the underlying writer when the custom.Writer is closed
 func NewWriterCloser(f io.Writer) *Writer {
 	return &Writer{w: f, data: pool.Get().([]byte), close: true}
@@ -184,25 +227,62 @@ func NewWriterCloser(f io.Writer) *Writer {
 
 // Creates a new buffered Zlib writer wrapping an io.Writer
 func NewZlibWriter(f io.Writer) *Writer {
-	return &Writer{w: zlib.NewWriter(f), data: pool.Get().([]byte), close: true}
+	return &Writer{w: zlib.NewWriter(f), data: pool.Get().([]byte), close: true, under: f}
 }
 
 // Creates a new buffered Snappy writer wrapping an io.Writer
 func NewSnappyWriter(f io.Writer) *Writer {
-	return &Writer{w: snappy.NewWriter(f), data: pool.Get().([]byte), close: true}
+	return &Writer{w: snappy.NewWriter(f), data: pool.Get().([]byte), close: true, under: f}
+}
+
+// Creates a new buffered Zstandard writer wrapping an io.Writer
+func NewZstdWriter(f io.Writer) *Writer {
+	z, err := zstd.NewWriter(f)
+	if err != nil {
+		panic(err)
+	}
+	return &Writer{w: z, data: pool.Get().([]byte), close: true, under: f}
+}
+
+// Creates a new buffered Zlib writer wrapping an io.Writer which compresses using a preset dictionary. The same dictionary must be given to NewZlibReaderDict to read it.
+func NewZlibWriterDict(f io.Writer, dict []byte) *Writer {
+	z, err := zlib.NewWriterLevelDict(f, zlib.DefaultCompression, dict)
+	if err != nil {
+		panic(err)
+	}
+	return &Writer{w: z, data: pool.Get().([]byte), close: true, under: f}
+}
+
+// Creates a new buffered Zstandard writer wrapping an io.Writer which compresses using a preset dictionary. The same dictionary must be given to NewZstdReaderDict to read it.
+func NewZstdWriterDict(f io.Writer, dict []byte) *Writer {
+	z, err := zstd.NewWriter(f, zstd.WithEncoderDictRaw(dictID(dict), dict))
+	if err != nil {
+		panic(err)
+	}
+	return &Writer{w: z, data: pool.Get().([]byte), close: true, under: f}
+}
+
+// Writes to the underlying io.Writer, keeping count of the offset
+func (w *Writer) write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.offset += int64(n)
+	if w.policy != nil && err == nil {
+		err = w.applyPolicy(n)
+	}
+	return n, err
 }
 
 // Write a slice of bytes to the buffer. Implements io.Writer interface
 func (w *Writer) Write(p []byte) (int, error) {
 	l := len(p)
-	if w.cursor + l > bufferLen {
+	if w.cursor + l > len(w.data) {
 		var err error
 		if w.cursor > 0 {
-			_, err = w.w.Write(w.data[0:w.cursor]) // flush
+			_, err = w.write(w.data[0:w.cursor]) // flush
 		}
-		if l > bufferLen { // data to write is longer than the length of the Writer
+		if l > len(w.data) { // data to write is longer than the length of the Writer
 			w.cursor = 0
-			return w.w.Write(p)
+			return w.write(p)
 		}
 		copy(w.data[0:l], p)
 		w.cursor = l
@@ -210,20 +290,23 @@ func (w *Writer) Write(p []byte) (int, error) {
 	}
 	copy(w.data[w.cursor:], p)
 	w.cursor += l
+	if w.policy != nil {
+		return l, w.checkPolicy()
+	}
 	return l, nil
 }
 
 // Write a string to the buffer
 func (w *Writer) WriteString(p string) (int, error) {
 	l := len(p)
-	if w.cursor + l > bufferLen {
+	if w.cursor + l > len(w.data) {
 		var err error
 		if w.cursor > 0 {
-			_, err = w.w.Write(w.data[0:w.cursor]) // flush
+			_, err = w.write(w.data[0:w.cursor]) // flush
 		}
-		if l > bufferLen { // data to write is longer than the length of the Writer
+		if l > len(w.data) { // data to write is longer than the length of the Writer
 			w.cursor = 0
-			return w.w.Write([]byte(p))
+			return w.write([]byte(p))
 		}
 		copy(w.data[0:l], p)
 		w.cursor = l
@@ -231,19 +314,25 @@ func (w *Writer) WriteString(p string) (int, error) {
 	}
 	copy(w.data[w.cursor:], p)
 	w.cursor += l
+	if w.policy != nil {
+		return l, w.checkPolicy()
+	}
 	return l, nil
 }
 
 // Write a byte to the buffer
 func (w *Writer) WriteByte(p byte) error {
-	if w.cursor < bufferLen {
+	if w.cursor < len(w.data) {
 		w.data[w.cursor] = p
 		w.cursor++
+		if w.policy != nil {
+			return w.checkPolicy()
+		}
 		return nil
 	}
 	var err error
 	if w.cursor > 0 {
-		_, err = w.w.Write(w.data[0:w.cursor]) // flush
+		_, err = w.write(w.data[0:w.cursor]) // flush
 	}
 	w.data[0] = p
 	w.cursor = 1
@@ -252,14 +341,17 @@ func (w *Writer) WriteByte(p byte) error {
 
 // Write a newline /n to the buffer
 func (w *Writer) Writeln() error {
-	if w.cursor < bufferLen {
+	if w.cursor < len(w.data) {
 		w.data[w.cursor] = '\n'
 		w.cursor++
+		if w.policy != nil {
+			return w.checkPolicy()
+		}
 		return nil
 	}
 	var err error
 	if w.cursor > 0 {
-		_, err = w.w.Write(w.data[0:w.cursor]) // flush
+		_, err = w.write(w.data[0:w.cursor]) // flush
 	}
 	w.data[0] = '\n'
 	w.cursor = 1
@@ -294,15 +386,18 @@ func (w *Writer) WriteInt(p int) (int, error) {
 
 // Write 2 bytes to the buffer
 func (w *Writer) Write2Bytes(p1, p2 byte) error {
-	if w.cursor < bufferLenMinus1 {
+	if w.cursor < len(w.data) - 1 {
 		w.data[w.cursor] = p1
 		w.data[w.cursor + 1] = p2
 		w.cursor += 2
+		if w.policy != nil {
+			return w.checkPolicy()
+		}
 		return nil
 	}
 	var err error
 	if w.cursor > 0 {
-		_, err = w.w.Write(w.data[0:w.cursor]) // flush
+		_, err = w.write(w.data[0:w.cursor]) // flush
 	}
 	w.data[0] = p1
 	w.data[1] = p2
@@ -313,16 +408,19 @@ func (w *Writer) Write2Bytes(p1, p2 byte) error {
 // Write 3 bytes to the buffer
 func (w *Writer) Write3Bytes(p1, p2, p3 byte) error {
 	cursor := w.cursor
-	if cursor < bufferLenMinus2 {
+	if cursor < len(w.data) - 2 {
 		w.data[cursor] = p1
 		w.data[cursor + 1] = p2
 		w.data[cursor + 2] = p3
 		w.cursor += 3
+		if w.policy != nil {
+			return w.checkPolicy()
+		}
 		return nil
 	}
 	var err error
 	if cursor > 0 {
-		_, err = w.w.Write(w.data[0:cursor]) // flush
+		_, err = w.write(w.data[0:cursor]) // flush
 	}
 	w.data[0] = p1
 	w.data[1] = p2
@@ -334,17 +432,20 @@ func (w *Writer) Write3Bytes(p1, p2, p3 byte) error {
 // Write 4 bytes to the buffer
 func (w *Writer) Write4Bytes(p1, p2, p3, p4 byte) error {
 	cursor := w.cursor
-	if cursor < bufferLenMinus3 {
+	if cursor < len(w.data) - 3 {
 		w.data[cursor] = p1
 		w.data[cursor + 1] = p2
 		w.data[cursor + 2] = p3
 		w.data[cursor + 3] = p4
 		w.cursor += 4
+		if w.policy != nil {
+			return w.checkPolicy()
+		}
 		return nil
 	}
 	var err error
 	if cursor > 0 {
-		_, err = w.w.Write(w.data[0:cursor]) // flush
+		_, err = w.write(w.data[0:cursor]) // flush
 	}
 	w.data[0] = p1
 	w.data[1] = p2
@@ -357,18 +458,21 @@ func (w *Writer) Write4Bytes(p1, p2, p3, p4 byte) error {
 // Write 5 bytes to the buffer
 func (w *Writer) Write5Bytes(p1, p2, p3, p4, p5 byte) error {
 	cursor := w.cursor
-	if cursor < bufferLenMinus4 {
+	if cursor < len(w.data) - 4 {
 		w.data[cursor] = p1
 		w.data[cursor + 1] = p2
 		w.data[cursor + 2] = p3
 		w.data[cursor + 3] = p4
 		w.data[cursor + 4] = p5
 		w.cursor += 5
+		if w.policy != nil {
+			return w.checkPolicy()
+		}
 		return nil
 	}
 	var err error
 	if cursor > 0 {
-		_, err = w.w.Write(w.data[0:cursor]) // flush
+		_, err = w.write(w.data[0:cursor]) // flush
 	}
 	w.data[0] = p1
 	w.data[1] = p2
@@ -382,7 +486,7 @@ func (w *Writer) Write5Bytes(p1, p2, p3, p4, p5 byte) error {
 // Write 6 bytes to the buffer
 func (w *Writer) Write6Bytes(p1, p2, p3, p4, p5, p6 byte) error {
 	cursor := w.cursor
-	if cursor < bufferLenMinus5 {
+	if cursor < len(w.data) - 5 {
 		w.data[cursor] = p1
 		w.data[cursor + 1] = p2
 		w.data[cursor + 2] = p3
@@ -390,11 +494,14 @@ func (w *Writer) Write6Bytes(p1, p2, p3, p4, p5, p6 byte) error {
 		w.data[cursor + 4] = p5
 		w.data[cursor + 5] = p6
 		w.cursor += 6
+		if w.policy != nil {
+			return w.checkPolicy()
+		}
 		return nil
 	}
 	var err error
 	if cursor > 0 {
-		_, err = w.w.Write(w.data[0:cursor]) // flush
+		_, err = w.write(w.data[0:cursor]) // flush
 	}
 	w.data[0] = p1
 	w.data[1] = p2
@@ -409,7 +516,7 @@ func (w *Writer) Write6Bytes(p1, p2, p3, p4, p5, p6 byte) error {
 // Write 7 bytes to the buffer
 func (w *Writer) Write7Bytes(p1, p2, p3, p4, p5, p6, p7 byte) error {
 	cursor := w.cursor
-	if cursor < bufferLenMinus6 {
+	if cursor < len(w.data) - 6 {
 		w.data[cursor] = p1
 		w.data[cursor + 1] = p2
 		w.data[cursor + 2] = p3
@@ -418,11 +525,14 @@ func (w *Writer) Write7Bytes(p1, p2, p3, p4, p5, p6, p7 byte) error {
 		w.data[cursor + 5] = p6
 		w.data[cursor + 6] = p7
 		w.cursor += 7
+		if w.policy != nil {
+			return w.checkPolicy()
+		}
 		return nil
 	}
 	var err error
 	if cursor > 0 {
-		_, err = w.w.Write(w.data[0:cursor]) // flush
+		_, err = w.write(w.data[0:cursor]) // flush
 	}
 	w.data[0] = p1
 	w.data[1] = p2
@@ -438,7 +548,7 @@ func (w *Writer) Write7Bytes(p1, p2, p3, p4, p5, p6, p7 byte) error {
 // Write 8 bytes to the buffer
 func (w *Writer) Write8Bytes(p1, p2, p3, p4, p5, p6, p7, p8 byte) error {
 	cursor := w.cursor
-	if cursor < bufferLenMinus7 {
+	if cursor < len(w.data) - 7 {
 		w.data[cursor] = p1
 		w.data[cursor + 1] = p2
 		w.data[cursor + 2] = p3
@@ -448,11 +558,14 @@ func (w *Writer) Write8Bytes(p1, p2, p3, p4, p5, p6, p7, p8 byte) error {
 		w.data[cursor + 6] = p7
 		w.data[cursor + 7] = p8
 		w.cursor += 8
+		if w.policy != nil {
+			return w.checkPolicy()
+		}
 		return nil
 	}
 	var err error
 	if cursor > 0 {
-		_, err = w.w.Write(w.data[0:cursor]) // flush
+		_, err = w.write(w.data[0:cursor]) // flush
 	}
 	w.data[0] = p1
 	w.data[1] = p2
@@ -469,7 +582,7 @@ func (w *Writer) Write8Bytes(p1, p2, p3, p4, p5, p6, p7, p8 byte) error {
 // Write 9 bytes to the buffer
 func (w *Writer) Write9Bytes(p1, p2, p3, p4, p5, p6, p7, p8, p9 byte) error {
 	cursor := w.cursor
-	if cursor < bufferLenMinus8 {
+	if cursor < len(w.data) - 8 {
 		w.data[cursor] = p1
 		w.data[cursor + 1] = p2
 		w.data[cursor + 2] = p3
@@ -480,11 +593,14 @@ func (w *Writer) Write9Bytes(p1, p2, p3, p4, p5, p6, p7, p8, p9 byte) error {
 		w.data[cursor + 7] = p8
 		w.data[cursor + 8] = p9
 		w.cursor += 9
+		if w.policy != nil {
+			return w.checkPolicy()
+		}
 		return nil
 	}
 	var err error
 	if cursor > 0 {
-		_, err = w.w.Write(w.data[0:cursor]) // flush
+		_, err = w.write(w.data[0:cursor]) // flush
 	}
 	w.data[0] = p1
 	w.data[1] = p2
@@ -765,12 +881,14 @@ func (w *Writer) WriteAll(a ...interface{}) (n int, err error) {
 // Flush the buffer and close the custom.Writer
 func (w *Writer) Close() (err error) {
 	if w.cursor > 0 {
-		_, err = w.w.Write(w.data[0:w.cursor])
+		_, err = w.write(w.data[0:w.cursor])
 		w.cursor = 0
 	}
-	if len(w.data) == bufferLen {
-		pool.Put(w.data)
-		w.data = nil
+	putBuffer(w.data)
+	w.data = nil
+	onClose := w.policy != nil && w.policy.OnClose
+	if onClose && w.under == nil && err == nil { // synced before closing, as w.w may be the file itself
+		err = w.syncBelow()
 	}
 	if w.close {
 		if sw, ok := w.w.(io.Closer); ok { // Attempt to close underlying writer if it has a Close() method
@@ -781,6 +899,11 @@ func (w *Writer) Close() (err error) {
 			}
 		}
 	}
+	if onClose && w.under != nil && err == nil { // synced after closing the compressor, so that the end of its stream is synced too
+		if s, ok := w.under.(syncer); ok {
+			err = s.Sync()
+		}
+	}
 	w.w = nil
 	return
 }
@@ -788,18 +911,26 @@ func (w *Writer) Close() (err error) {
 // Flush the buffer of custom.Writer to the underlying io.Writer. This is not usually necessary as long as you remember to Close()
 func (w *Writer) Flush() (err error) {
 	if w.cursor > 0 {
-		_, err = w.w.Write(w.data[0:w.cursor])
+		_, err = w.write(w.data[0:w.cursor])
 		w.cursor = 0
 	}
 	return
 }
 
 // Flushes the buffer to the underlying writer, closing it if this is a WriterCloser and then transfers to a new writer (no longer a WriterCloser)
+// A hashing writer starts a new hash of what is written to the new writer.
 func (w *Writer) Reset(newwriter io.Writer) (err error) {
 	if w.cursor > 0 {
-		_, err = w.w.Write(w.data[0:w.cursor])
+		_, err = w.write(w.data[0:w.cursor])
 		w.cursor = 0
 	}
+	w.offset, w.unsynced, w.seeked = 0, 0, false
+	if hw, ok := w.w.(*hashWriter); ok {
+		hw.h.Reset()
+		hw.w = newwriter
+		w.under = newwriter
+		return
+	}
 	if w.close {
 		if sw, ok := w.w.(io.Closer); ok { // Attempt to close underlying writer if it has a Close() method
 			if err == nil {
@@ -811,9 +942,66 @@ func (w *Writer) Reset(newwriter io.Writer) (err error) {
 		w.close = false
 	}
 	w.w = newwriter
+	w.under = nil
 	return
 }
 
+// Returns the position that the next byte written will have in the output, counted from where the custom.Writer started writing (or from the start of the underlying io.Seeker once Seek or WriteAt has been called).
+func (w *Writer) Offset() int64 {
+	return w.offset + int64(w.cursor)
+}
+
+// Finds where the underlying io.Seeker really is, so that offsets are the same as its. Only done once, and if it cannot seek (e.g. a pipe) then offsets stay counted from where the custom.Writer started.
+func (w *Writer) seekOffset() {
+	sw, ok := w.w.(io.Seeker)
+	if !ok || w.seeked {
+		return
+	}
+	w.seeked = true
+	if cur, err := sw.Seek(0, io.SeekCurrent); err == nil { // everything before data[0] has been written, so this is where data[0] goes
+		w.offset = cur
+	}
+}
+
+// Flushes the buffer and then seeks on the underlying io.Writer, which must implement io.Seeker (e.g. *os.File). Seek(0, io.SeekCurrent) returns Offset without flushing, and so works on any custom.Writer. Implements io.Seeker
+// If the underlying io.Writer is an io.Seeker then offsets are those of the io.Seeker, counted from its start rather than from where the custom.Writer started writing.
+func (w *Writer) Seek(offset int64, whence int) (int64, error) {
+	w.seekOffset()
+	if offset == 0 && whence == io.SeekCurrent {
+		return w.Offset(), nil
+	}
+	sw, ok := w.w.(io.Seeker)
+	if !ok {
+		return 0, errors.New(`Does not implement io.Seeker`)
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	abs, err := sw.Seek(offset, whence)
+	if err == nil {
+		w.offset = abs
+	}
+	return abs, err
+}
+
+// Writes p at offset off (as given by Seek or Offset) without changing the current position, e.g. to fill in a header once the rest of the file is written. Implements io.WriterAt
+// If the region is still in the buffer then it is patched there. Otherwise the buffer is flushed and p is written with the WriteAt method of the underlying io.Writer, which must implement io.WriterAt (e.g. *os.File).
+// As with Seek, if the underlying io.Writer is an io.Seeker then off is counted from its start, so that WriteAt(p, 0) writes at the start of the file even if the custom.Writer was created part way through it.
+func (w *Writer) WriteAt(p []byte, off int64) (int, error) {
+	w.seekOffset()
+	if off >= w.offset && off + int64(len(p)) <= w.offset + int64(w.cursor) {
+		return copy(w.data[off - w.offset:], p), nil
+	}
+	wa, ok := w.w.(io.WriterAt)
+	if !ok {
+		return 0, errors.New(`Does not implement io.WriterAt`)
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	return wa.WriteAt(p, off)
+}
+
 // -------- GROWING BUFFER --------
 
 type Buffer struct {
@@ -821,13 +1009,32 @@ type Buffer struct {
 	cursor, length int
 }
 
-// Creates a new buffer
+// A reserved space in a Buffer which is filled in later with one of the Patch methods
+type Mark struct {
+	offset, length int
+}
+
+var ErrMarkLength = errors.New(`Value does not match the length reserved`)
+var ErrMarkInvalid = errors.New(`Mark is beyond the end of the buffer`)
+
+// Returns the offset of the reserved space in the Buffer
+func (m Mark) Offset() int {
+	return m.offset
+}
+
+// Creates a new buffer with room for at least l bytes, and never less than the default buffer size
 func NewBuffer(l int) *Buffer {
 	if l <= bufferLen {
 		return &Buffer{data: pool.Get().([]byte), length: bufferLen}
-	} else {
-		return &Buffer{data: make([]byte, l), length: l}
 	}
+	data := getBuffer(l)
+	return &Buffer{data: data, length: len(data)}
+}
+
+// Creates a new buffer with room for at least size bytes before it has to grow, rounded up to a power of two
+func NewBufferSize(size int) *Buffer {
+	data := getBuffer(size)
+	return &Buffer{data: data, length: len(data)}
 }
 
 // Wraps the written data in a new reader. The original Buffer will need to be closed after all the reading is done.
@@ -856,17 +1063,34 @@ func (w *Buffer) ReadFrom(r io.Reader) (int, error) {
 	return n, nil
 }
 
+// Moves the data into a buffer from the pools with room for l more bytes, at least doubling in size, and returns the old one to its pool
 func (w *Buffer) grow(l int) {
-	newLength := (w.length + l) * 2
-	newAr := make([]byte, newLength)
-	copy(newAr, w.data)
-	if w.length == bufferLen {
-		pool.Put(w.data)
-	}
-	w.length = newLength
+	newLength := w.length * 2
+	if need := w.cursor + l; need > newLength {
+		newLength = need
+	}
+	newAr := getBuffer(newLength)
+	copy(newAr, w.data[0:w.cursor])
+	putBuffer(w.data)
+	w.length = len(newAr)
 	w.data = newAr
 }
 
+// Grows the buffer, if necessary, to guarantee room for another n bytes without growing again. Panics if n is negative.
+func (w *Buffer) Grow(n int) {
+	if n < 0 {
+		panic(errors.New("custom.Buffer.Grow: negative count"))
+	}
+	if w.cursor + n > w.length {
+		w.grow(n)
+	}
+}
+
+// Returns how many bytes the buffer can hold before it has to grow
+func (w *Buffer) Cap() int {
+	return w.length
+}
+
 // Write a slice of bytes to the buffer. Implements io.Writer interface
 func (w *Buffer) Write(p []byte) (int, error) {
 	l := len(p)
@@ -1322,6 +1546,127 @@ func (w *Buffer) WriteAll(a ...interface{}) (n int, err error) {
 	return
 }
 
+// Reserves n bytes at the current position to be filled in later with one of the Patch methods, such as a length or offset that is not yet known. The reserved bytes are zero until patched. Panics if n is negative.
+func (w *Buffer) Reserve(n int) Mark {
+	if n < 0 {
+		panic(errors.New("custom.Buffer.Reserve: negative count"))
+	}
+	if w.cursor + n > w.length {
+		w.grow(n)
+	}
+	reserved := w.data[w.cursor:w.cursor+n]
+	for i := range reserved {
+		reserved[i] = 0
+	}
+	w.cursor += n
+	return Mark{offset: w.cursor - n, length: n}
+}
+
+// Returns the number of bytes written after the reserved space of the Mark, e.g. the length of a section whose length was reserved
+func (w *Buffer) Since(m Mark) int {
+	return w.cursor - m.offset - m.length
+}
+
+// Returns the reserved space of the Mark, checking that it is the expected length and is still within what has been written
+func (w *Buffer) patch(m Mark, l int) ([]byte, error) {
+	if m.length != l {
+		return nil, ErrMarkLength
+	}
+	if m.offset + m.length > w.cursor {
+		return nil, ErrMarkInvalid
+	}
+	return w.data[m.offset:m.offset+l], nil
+}
+
+// Fills in 2 bytes reserved with Reserve(2) with a uint16 encoded as with WriteUint16
+func (w *Buffer) PatchUint16(m Mark, v uint16) error {
+	b, err := w.patch(m, 2)
+	if err != nil {
+		return err
+	}
+	b[0], b[1] = byte(v), byte(v >> 8)
+	return nil
+}
+
+// Fills in 3 bytes reserved with Reserve(3) with a uint32 encoded as with WriteUint24
+func (w *Buffer) PatchUint24(m Mark, v uint32) error {
+	b, err := w.patch(m, 3)
+	if err != nil {
+		return err
+	}
+	b[0], b[1], b[2] = byte(v), byte(v >> 8), byte(v >> 16)
+	return nil
+}
+
+// Fills in 4 bytes reserved with Reserve(4) with a uint32 encoded as with WriteUint32
+func (w *Buffer) PatchUint32(m Mark, v uint32) error {
+	b, err := w.patch(m, 4)
+	if err != nil {
+		return err
+	}
+	b[0], b[1], b[2], b[3] = byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)
+	return nil
+}
+
+// Fills in 6 bytes reserved with Reserve(6) with a uint64 encoded as with WriteUint48
+func (w *Buffer) PatchUint48(m Mark, v uint64) error {
+	b, err := w.patch(m, 6)
+	if err != nil {
+		return err
+	}
+	b[0], b[1], b[2], b[3], b[4], b[5] = byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24), byte(v >> 32), byte(v >> 40)
+	return nil
+}
+
+// Fills in 8 bytes reserved with Reserve(8) with a uint64 encoded as with WriteUint64
+func (w *Buffer) PatchUint64(m Mark, v uint64) error {
+	b, err := w.patch(m, 8)
+	if err != nil {
+		return err
+	}
+	b[0], b[1], b[2], b[3], b[4], b[5], b[6], b[7] = byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24), byte(v >> 32), byte(v >> 40), byte(v >> 48), byte(v >> 56)
+	return nil
+}
+
+// Fills in 2-9 reserved bytes with a uint64 that can be read with ReadUint64Variable. Since the value is not known when reserving, the whole reservation is used, so Reserve(9) fits any uint64.
+// Returns ErrMarkLength if the value needs more bytes than were reserved.
+func (w *Buffer) PatchUint64Variable(m Mark, v uint64) error {
+	if m.length < 2 || m.length > 9 || int(numbytes(v)) > m.length - 1 {
+		return ErrMarkLength
+	}
+	b, err := w.patch(m, m.length)
+	if err != nil {
+		return err
+	}
+	b[0] = byte(m.length - 1)
+	for i := 1; i < m.length; i++ {
+		b[i] = byte(v)
+		v >>= 8
+	}
+	return nil
+}
+
+// Writes p at offset off, overwriting what has already been written. If it extends beyond what has been written then the buffer is extended, with any gap filled with zeros. Implements io.WriterAt interface
+func (w *Buffer) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New(`custom.Buffer.WriteAt: negative offset`)
+	}
+	end := int(off) + len(p)
+	if end > w.cursor {
+		if end > w.length {
+			w.grow(end - w.cursor)
+		}
+		if int(off) > w.cursor {
+			gap := w.data[w.cursor:off]
+			for i := range gap {
+				gap[i] = 0
+			}
+		}
+		w.cursor = end
+	}
+	return copy(w.data[off:end], p), nil
+}
+
 // Reset (empty) the buffer
 func (w *Buffer) Reset() {
 	w.cursor = 0
@@ -1352,11 +1697,9 @@ func (w *Buffer) String() string {
 
 // Releases the buffer back to the pool
 func (w *Buffer) Close() error {
-	if w.length == bufferLen {
-		pool.Put(w.data)
-		w.length = 0
-		w.data = nil
-	}
+	putBuffer(w.data)
+	w.length = 0
+	w.data = nil
 	return nil
 }
 
@@ -1381,7 +1724,12 @@ type Reader struct {
 	at int		// the cursor for where I am in buf
 	n int		// how much uncompressed but as of yet unparsed data is left in buf
 	buf []byte	// the buffer for reading data
-	close, eof bool
+	pos int64	// how much has been read from the underlying io.Reader, i.e. the position of the end of buf[0:at+n]
+	dict []byte	// the preset dictionary of the decompressor, if any
+	h hash.Hash	// the hash of a hashing reader
+	limits *Limits	// set with SetLimits
+	close, eof, seeked bool
+	shared bool	// buf belongs to the parent of a section created with Limit
 }
 
 // Creates a new buffered reader wrapping an io.Reader
@@ -1389,33 +1737,206 @@ func NewReader(f io.Reader) *Reader {
 	return &Reader{f: f, buf: pool.Get().([]byte)}
 }
 
-// Creates a new buffered reader wrapping an io.Reader which contains Zlib compressed data
+// Creates a new buffered reader wrapping an io.Reader with a buffer of at least size bytes, rounded up to a power of two. Smaller buffers save memory with many connections, larger ones can be faster for bulk I/O.
+func NewReaderSize(f io.Reader, size int) *Reader {
+	return &Reader{f: f, buf: getBuffer(size)}
+}
+
+// Creates a new buffered reader wrapping an io.Reader which contains Zlib compressed data. Panics if the Zlib header is invalid.
 func NewZlibReader(f io.Reader) *Reader {
-	z, err := zlib.NewReader(f)
+	r, err := OpenZlibReader(f)
 	if err != nil {
 		panic(err)
 	}
-	return &Reader{f: z, buf: pool.Get().([]byte), close: true}
+	return r
 }
 
-// Creates a new buffered reader wrapping an io.Reader which contains Snappy compressed data
+// Creates a new buffered reader wrapping an io.Reader which contains Snappy compressed data. Panics if the Snappy stream identifier is invalid.
 func NewSnappyReader(f io.Reader) *Reader {
-	return &Reader{f: snappy.NewReader(f), buf: pool.Get().([]byte), close: true}
+	r, err := OpenSnappyReader(f)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Creates a new buffered reader wrapping an io.Reader which contains Gzip compressed data. Panics if the Gzip header is invalid.
+func NewGzipReader(f io.Reader) *Reader {
+	r, err := OpenGzipReader(f)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Creates a new buffered reader wrapping an io.Reader which contains Zstandard compressed data. Panics if the decoder cannot be created.
+func NewZstdReader(f io.Reader) *Reader {
+	r, err := OpenZstdReader(f)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Creates a new buffered reader wrapping an io.Reader which contains Zlib compressed data. Returns an error if the Zlib header is invalid.
+func OpenZlibReader(f io.Reader) (*Reader, error) {
+	z, err := zlib.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{f: z, buf: pool.Get().([]byte), close: true}, nil
+}
+
+// Creates a new buffered reader wrapping an io.Reader which contains Snappy compressed data. Returns an error if the stream does not begin with the Snappy stream identifier.
+func OpenSnappyReader(f io.Reader) (*Reader, error) {
+	r := &Reader{f: f, buf: pool.Get().([]byte), close: true}
+	if err := r.fill(len(snappyMagic)); err != nil && err != io.EOF {
+		pool.Put(r.buf)
+		return nil, err
+	}
+	if r.n < len(snappyMagic) || string(r.buf[0:len(snappyMagic)]) != snappyMagic {
+		pool.Put(r.buf)
+		return nil, ErrInvalidHeader
+	}
+	r.f = snappy.NewReader(r.peeked())
+	return r, nil
+}
+
+// Creates a new buffered reader wrapping an io.Reader which contains Gzip compressed data. Returns an error if the Gzip header is invalid.
+func OpenGzipReader(f io.Reader) (*Reader, error) {
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{f: z, buf: pool.Get().([]byte), close: true}, nil
+}
+
+// Creates a new buffered reader wrapping an io.Reader which contains Zstandard compressed data. Returns an error if the decoder cannot be created.
+func OpenZstdReader(f io.Reader) (*Reader, error) {
+	z, err := zstd.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{f: zstdReader{z}, buf: pool.Get().([]byte), close: true}, nil
+}
+
+// Creates a new buffered reader wrapping an io.Reader which contains Zlib compressed data written with NewZlibWriterDict. Panics if the Zlib header is invalid.
+func NewZlibReaderDict(f io.Reader, dict []byte) *Reader {
+	r, err := OpenZlibReaderDict(f, dict)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Creates a new buffered reader wrapping an io.Reader which contains Zstandard compressed data written with NewZstdWriterDict. Panics if the decoder cannot be created.
+func NewZstdReaderDict(f io.Reader, dict []byte) *Reader {
+	r, err := OpenZstdReaderDict(f, dict)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Creates a new buffered reader wrapping an io.Reader which contains Zlib compressed data written with NewZlibWriterDict. Returns an error if the Zlib header is invalid or the dictionary does not match.
+func OpenZlibReaderDict(f io.Reader, dict []byte) (*Reader, error) {
+	z, err := zlib.NewReaderDict(f, dict)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{f: z, buf: pool.Get().([]byte), close: true, dict: dict}, nil
+}
+
+// Creates a new buffered reader wrapping an io.Reader which contains Zstandard compressed data written with NewZstdWriterDict. Returns an error if the decoder cannot be created.
+func OpenZstdReaderDict(f io.Reader, dict []byte) (*Reader, error) {
+	z, err := zstd.NewReader(f, zstd.WithDecoderDictRaw(dictID(dict), dict))
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{f: zstdReader{z}, buf: pool.Get().([]byte), close: true}, nil
+}
+
+// Creates a new buffered reader wrapping an io.Reader which may or may not contain compressed data.
+// The first bytes are peeked to detect a Zlib, Gzip, Zstandard or Snappy stream, and the matching decompressor is used. If none match then the data is read uncompressed.
+// Zlib has only a 2 byte header, so uncompressed data that begins like one (e.g. the text "x^") is only treated as Zlib if the bytes peeked after it also decompress.
+func NewAutoReader(f io.Reader) (*Reader, error) {
+	r := &Reader{f: f, buf: pool.Get().([]byte)}
+	err := r.fill(len(snappyMagic))
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		pool.Put(r.buf)
+		return nil, err
+	}
+	complete := err != nil // the whole stream has been peeked
+	var z io.Reader
+	err = nil
+	switch b := r.buf[0:r.n]; {
+		case len(b) >= len(snappyMagic) && string(b[0:len(snappyMagic)]) == snappyMagic:
+			z = snappy.NewReader(r.peeked())
+		case len(b) >= 4 && b[0] == 0x28 && b[1] == 0xB5 && b[2] == 0x2F && b[3] == 0xFD:
+			var d *zstd.Decoder
+			if d, err = zstd.NewReader(r.peeked()); err == nil {
+				z = zstdReader{d}
+			}
+		case len(b) >= 2 && b[0] == 0x1F && b[1] == 0x8B:
+			z, err = gzip.NewReader(r.peeked())
+		case len(b) >= 2 && zlibHeader(b) && zlibDecodes(b, complete):
+			z, err = zlib.NewReader(r.peeked())
+		default:
+			return r, nil // uncompressed, the peeked bytes remain in the buffer
+	}
+	if err != nil {
+		pool.Put(r.buf)
+		return nil, err
+	}
+	r.f = z
+	r.close = true
+	return r, nil
+}
+
+// Whether b begins with a Zlib header: deflate with a window of at most 32KB, no preset dictionary, and a valid header checksum. Any FLEVEL is valid.
+func zlibHeader(b []byte) bool {
+	return b[0] & 0x0F == 8 && b[0] >> 4 <= 7 && b[1] & 0x20 == 0 && (uint16(b[0]) << 8 | uint16(b[1])) % 31 == 0
+}
+
+// Whether the peeked bytes b decompress as Zlib for as far as they go, or entirely if complete is true because they are the whole stream
+func zlibDecodes(b []byte, complete bool) bool {
+	z, err := zlib.NewReader(bytes.NewReader(b))
+	if err == nil {
+		_, err = io.CopyN(io.Discard, z, bufferLen) // a limit on the output, as a few bytes can decompress to a great many
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	return err == nil || (err == io.ErrUnexpectedEOF && !complete)
+}
+
+// Returns an io.Reader which replays the bytes currently in the buffer followed by the rest of the underlying io.Reader, and empties the buffer
+func (r *Reader) peeked() io.Reader {
+	p := make([]byte, r.n)
+	copy(p, r.buf[r.at:r.at+r.n])
+	r.at, r.n, r.pos = 0, 0, 0
+	return io.MultiReader(bytes.NewReader(p), r.f)
 }
 
 func (r *Reader) fill(x int) error {
+	if err := r.limits.total(r.pos, x - r.n); err != nil {
+		return err
+	}
 	copy(r.buf, r.buf[r.at:r.at+r.n])
 	r.at = 0
-	m, err := r.f.Read(r.buf[r.n:])
+	end := r.n + r.limits.room(r.pos, len(r.buf) - r.n)
+	m, err := r.f.Read(r.buf[r.n:end])
 	r.n += m
+	r.pos += int64(m)
 	if err != nil {
 		if m == 0 {
 			return err
 		}
 	}
 	for r.n < x {
-		m, err = r.f.Read(r.buf[r.n:])
+		m, err = r.f.Read(r.buf[r.n:end])
 		r.n += m
+		r.pos += int64(m)
 		if err != nil {
 			if m == 0 {
 				return err
@@ -1426,9 +1947,13 @@ func (r *Reader) fill(x int) error {
 }
 
 func (r *Reader) fill1() {
+	if err := r.limits.total(r.pos, 1); err != nil {
+		panic(err)
+	}
 	r.at = 0
-	m, err := r.f.Read(r.buf)
+	m, err := r.f.Read(r.buf[0:r.limits.room(r.pos, len(r.buf))])
 	r.n = m
+	r.pos += int64(m)
 	if err != nil {
 		if m == 0 {
 			panic(err)
@@ -1437,21 +1962,44 @@ func (r *Reader) fill1() {
 }
 
 // Populate slice of bytes
+// With MaxTotalBytes set, Read stops at the limit and then returns the *ErrLimitViolation only if the stream goes on beyond it, or io.EOF if it ends there.
 func (r *Reader) Read(b []byte) (int, error) {
 	x := len(b)
-	if x > bufferLen { // the user has requested more data than the buffer size
+	if r.limits != nil {
+		if room := r.limits.room(r.Offset(), x); room < x {
+			if room == 0 && x > 0 {
+				return 0, r.pastLimit()
+			}
+			x = room
+			b = b[0:x]
+		}
+	}
+	if x > len(r.buf) { // the user has requested more data than the buffer size
+		if err := r.limits.total(r.pos, x - r.n); err != nil {
+			return 0, err
+		}
 		n := r.n
 		copy(b, r.buf[r.at:r.at+n]) // copy what we have in the buffer
 		r.at, r.n = 0, 0 // buffer is now empty
-		if i, err := io.ReadAtLeast(r.f, b[n:], x-n); err != nil { // then read the remainder directly from the src
+		i, err := io.ReadAtLeast(r.f, b[n:], x-n) // then read the remainder directly from the src
+		r.pos += int64(i)
+		if err != nil {
+			if err == ErrLimitExceeded {
+				err = io.EOF
+			}
 			return n+i, err
 		}
 		return x, nil
 	}
 	var err error
 	if r.n < x {
-		if err = r.fill(x); err == io.EOF {
-			x = r.n
+		if err = r.fill(x); err != nil {
+			if err == ErrLimitExceeded {
+				err = io.EOF
+			}
+			if r.n < x {
+				x = r.n
+			}
 		}
 	}
 	copy(b, r.buf[r.at:r.at+x]) // must be copied to avoid memory leak
@@ -1460,14 +2008,38 @@ func (r *Reader) Read(b []byte) (int, error) {
 	return x, err
 }
 
+// Returns the error for a Read at MaxTotalBytes: io.EOF if the stream ends there, otherwise the *ErrLimitViolation. Finding out reads 1 byte beyond the limit, which is dropped so that nothing can read it.
+func (r *Reader) pastLimit() error {
+	violation := r.limits.total(r.Offset(), 1)
+	if r.n > 0 || r.Offset() > r.limits.MaxTotalBytes { // already known to go on
+		return violation
+	}
+	r.at = 0
+	m, err := io.ReadFull(r.f, r.buf[0:1])
+	r.pos += int64(m)
+	if m == 0 {
+		if err == io.EOF || err == ErrLimitExceeded {
+			return io.EOF
+		}
+		return err
+	}
+	return violation
+}
+
 // Reads x bytes and returns this slice of bytes as a copy.
 func (r *Reader) Readx(x int) []byte {
-	b := make([]byte, x)
-	if x > bufferLen { // the user has requested more data than the buffer size
+	r.limits.alloc(x)
+	if x > len(r.buf) { // the user has requested more data than the buffer size
+		if err := r.limits.total(r.pos, x - r.n); err != nil {
+			panic(err)
+		}
+		b := make([]byte, x)
 		n := r.n
 		copy(b, r.buf[r.at:r.at+n]) // copy what we have in the buffer
 		r.at, r.n = 0, 0 // buffer is now empty
-		if _, err := io.ReadAtLeast(r.f, b[n:], x-n); err != nil { // then read the remainder directly from the src
+		i, err := io.ReadAtLeast(r.f, b[n:], x-n) // then read the remainder directly from the src
+		r.pos += int64(i)
+		if err != nil {
 			panic(err)
 		}
 		return b
@@ -1477,6 +2049,7 @@ func (r *Reader) Readx(x int) []byte {
 			panic(err)
 		}
 	}
+	b := make([]byte, x)
 	copy(b, r.buf[r.at:r.at+x]) // must be copied to avoid memory leak
 	r.at += x
 	r.n -= x
@@ -1485,12 +2058,18 @@ func (r *Reader) Readx(x int) []byte {
 
 // Reads x bytes and returns a slice of the buffer. This slice is not a copy and so must be used or copied before the next read.
 func (r *Reader) ReadxRaw(x int) []byte {
-	if x > bufferLen { // the user has requested more data than the buffer size
+	if x > len(r.buf) { // the user has requested more data than the buffer size
+		r.limits.alloc(x)
+		if err := r.limits.total(r.pos, x - r.n); err != nil {
+			panic(err)
+		}
 		b := make([]byte, x)
 		n := r.n
 		copy(b, r.buf[r.at:r.at+n]) // copy what we have in the buffer
 		r.at, r.n = 0, 0 // buffer is now empty
-		if _, err := io.ReadAtLeast(r.f, b[n:], x-n); err != nil { // then read the remainder directly from the src
+		i, err := io.ReadAtLeast(r.f, b[n:], x-n) // then read the remainder directly from the src
+		r.pos += int64(i)
+		if err != nil {
 			panic(err)
 		}
 		return b
@@ -1809,36 +2388,93 @@ func (r *Reader) ReadFloat64() float64 {
 
 // Read and decode a string encoded with WriteString8
 func (r *Reader) ReadString8() string {
-	return string(r.ReadxRaw(int(r.ReadByte())))
+	x := int(r.ReadByte())
+	r.limits.str(x)
+	return string(r.ReadxRaw(x))
 }
 
 // Read and decode a string encoded with WriteString16
 func (r *Reader) ReadString16() string {
-	return string(r.ReadxRaw(int(r.ReadUint16())))
+	x := int(r.ReadUint16())
+	r.limits.str(x)
+	return string(r.ReadxRaw(x))
 }
 
 // Read and decode a string encoded with WriteString32
 func (r *Reader) ReadString32() string {
-	return string(r.ReadxRaw(int(r.ReadUint32())))
+	x := int(r.ReadUint32())
+	r.limits.str(x)
+	return string(r.ReadxRaw(x))
 }
 
 // Read and decode a string encoded with WriteString8 as slice of bytes
 func (r *Reader) ReadBytes8() []byte {
-	return r.Readx(int(r.ReadByte()))
+	x := int(r.ReadByte())
+	r.limits.str(x)
+	return r.Readx(x)
 }
 
 // Read and decode a string encoded with WriteString16 as slice of bytes
 func (r *Reader) ReadBytes16() []byte {
-	return r.Readx(int(r.ReadUint16()))
+	x := int(r.ReadUint16())
+	r.limits.str(x)
+	return r.Readx(x)
 }
 
 // Read and decode a string encoded with WriteString32 as slice of bytes
 func (r *Reader) ReadBytes32() []byte {
-	return r.Readx(int(r.ReadUint32()))
+	x := int(r.ReadUint32())
+	r.limits.str(x)
+	return r.Readx(x)
 }
 
+// Moves forward x bytes without returning anything. Fixed length types can be skipped with Discard, e.g. Discard(8) for a uint64.
+// If x is more than the buffer holds and the underlying io.Reader is an io.Seeker then it is seeked past instead of read. Panics with io.ErrUnexpectedEOF if there are fewer than x bytes left, and panics if x is negative.
 func (r *Reader) Discard(x int) {
+	if x < 0 {
+		panic(errors.New(`custom.Reader.Discard: negative count`))
+	}
 	if r.n < x {
+		if x > len(r.buf) { // more than the buffer can hold, so skip what is buffered and then seek or read past the rest
+			if err := r.limits.total(r.pos, x - r.n); err != nil {
+				panic(err)
+			}
+			x -= r.n
+			r.at, r.n = 0, 0
+			if sw, ok := r.f.(io.Seeker); ok {
+				if cur, err := sw.Seek(0, io.SeekCurrent); err == nil { // otherwise it cannot seek (e.g. a pipe) and is read past instead
+					end, err := sw.Seek(0, io.SeekEnd) // seeking past the end would succeed, so the size is checked first
+					if err != nil {
+						panic(err)
+					}
+					if cur + int64(x) > end {
+						r.pos += end - cur
+						panic(io.ErrUnexpectedEOF)
+					}
+					if _, err = sw.Seek(cur + int64(x), io.SeekStart); err != nil {
+						panic(err)
+					}
+					r.pos += int64(x)
+					return
+				}
+			}
+			for x > 0 {
+				l := x
+				if l > len(r.buf) {
+					l = len(r.buf)
+				}
+				m, err := io.ReadFull(r.f, r.buf[0:l])
+				r.pos += int64(m)
+				if err != nil {
+					if err == io.EOF {
+						err = io.ErrUnexpectedEOF
+					}
+					panic(err)
+				}
+				x -= m
+			}
+			return
+		}
 		if err := r.fill(x); err != nil {
 			panic(err)
 		}
@@ -1847,13 +2483,213 @@ func (r *Reader) Discard(x int) {
 	r.n -= x
 }
 
-// Seeks on the underlying io.Reader
+// Skip a string encoded with WriteString8 without reading it
+func (r *Reader) SkipString8() {
+	r.Discard(int(r.ReadByte()))
+}
+
+// Skip a string encoded with WriteString16 without reading it
+func (r *Reader) SkipString16() {
+	r.Discard(int(r.ReadUint16()))
+}
+
+// Skip a string encoded with WriteString32 without reading it
+func (r *Reader) SkipString32() {
+	r.Discard(int(r.ReadUint32()))
+}
+
+// Skip a slice of bytes encoded with WriteBytes8
+func (r *Reader) SkipBytes8() {
+	r.Discard(int(r.ReadByte()))
+}
+
+// Skip a slice of bytes encoded with WriteBytes16
+func (r *Reader) SkipBytes16() {
+	r.Discard(int(r.ReadUint16()))
+}
+
+// Skip a slice of bytes encoded with WriteBytes32
+func (r *Reader) SkipBytes32() {
+	r.Discard(int(r.ReadUint32()))
+}
+
+// Skip a uint16 encoded with WriteUint16Variable
+func (r *Reader) SkipUint16Variable() {
+	if r.ReadByte() == 255 {
+		r.Discard(2)
+	}
+}
+
+// Skip an int16 encoded with WriteInt16Variable
+func (r *Reader) SkipInt16Variable() {
+	if r.ReadByte() == 255 {
+		r.Discard(2)
+	}
+}
+
+// Skip a uint64 encoded with WriteUint64Variable
+func (r *Reader) SkipUint64Variable() {
+	r.Discard(int(r.ReadByte()))
+}
+
+// Skip 2 uint64s encoded with Write2Uint64sVariable
+func (r *Reader) Skip2Uint64sVariable() {
+	s := r.ReadByte()
+	r.Discard(int(s >> 4) + int(s & 15))
+}
+
+// Skip a UTF8 character or a rune encoded with WriteRune
+func (r *Reader) SkipUTF8() {
+	first := r.ReadByte()
+	if first < 128 { // length 1
+		return
+	}
+	if first & 32 == 0 { // length 2
+		r.Discard(1)
+	} else {
+		r.Discard(2)
+	}
+}
+
+// Returns the next n bytes without consuming them, or fewer if the stream ends first. Panics with ErrPeekTooLarge if n is larger than the buffer.
+// The slice is not a copy and is only valid until the next read, peek or unread. If n is more than Buffered then the buffer is refilled, after which the bytes already read can no longer be unread.
+func (r *Reader) Peek(n int) []byte {
+	if n < 0 {
+		panic(errors.New(`custom.Reader.Peek: negative count`))
+	}
+	if n > len(r.buf) {
+		if s, ok := r.f.(*sectionReader); !ok || s.remaining > 0 {
+			panic(ErrPeekTooLarge)
+		}
+		n = len(r.buf) // a section copied by Limit, which has only as much buffer as it has data
+	}
+	if r.n < n {
+		if err := r.fill(n); err != nil && err != io.EOF && err != ErrLimitExceeded {
+			panic(err)
+		}
+		if r.n < n {
+			n = r.n
+		}
+	}
+	return r.buf[r.at:r.at+n]
+}
+
+// Returns the next byte without consuming it
+func (r *Reader) PeekByte() uint8 {
+	if r.n == 0 {
+		r.fill1()
+	}
+	return r.buf[r.at]
+}
+
+// Steps back one byte, so that the last byte read is read again. Returns ErrUnread if it is no longer in the buffer, which is the case after a Peek or PeekByte that had to read from the underlying io.Reader, i.e. for more than Buffered bytes.
+func (r *Reader) UnreadByte() error {
+	if r.at == 0 {
+		return ErrUnread
+	}
+	r.at--
+	r.n++
+	return nil
+}
+
+// Steps back one rune, so that the rune read by the preceding ReadRune is read again. Returns ErrUnread if it is no longer in the buffer, which is the case after a Peek or PeekByte that had to read from the underlying io.Reader, i.e. for more than Buffered bytes.
+func (r *Reader) UnreadRune() error {
+	if r.at == 0 {
+		return ErrUnread
+	}
+	rn, size := utf8.DecodeLastRune(r.buf[0:r.at])
+	if rn == utf8.RuneError && size <= 1 {
+		return ErrUnread
+	}
+	r.at -= size
+	r.n += size
+	return nil
+}
+
+// Returns how many bytes can be read from the buffer without reading from the underlying io.Reader
+func (r *Reader) Buffered() int {
+	return r.n
+}
+
+// Returns the position of the next byte to be read, counted from where the custom.Reader started reading (or from the start of the underlying io.Seeker once Seek has been called).
+func (r *Reader) Offset() int64 {
+	return r.pos - int64(r.n)
+}
+
+// Implements io.Seeker (see io.Seeker for usage)
+// If the target is still in the buffer then the buffer is repositioned without touching the underlying io.Reader. Otherwise the buffer is emptied and the underlying io.Reader, which must implement io.Seeker, is seeked.
 func (r *Reader) Seek(offset int64, whence int) (int64, error) {
-	if sw, ok := r.f.(io.Seeker); ok {
-		r.at, r.n = 0, 0
-		return sw.Seek(offset, whence)
+	sw, ok := r.f.(io.Seeker)
+	if ok && !r.seeked { // find where the underlying io.Seeker really is, so that offsets are the same as its
+		cur, err := sw.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, err
+		}
+		r.pos, r.seeked = cur, true
+	}
+	var abs int64
+	switch whence {
+		case io.SeekStart:
+			abs = offset
+		case io.SeekCurrent:
+			abs = r.pos - int64(r.n) + offset
+		case io.SeekEnd:
+			if !ok {
+				return 0, errors.New(`Does not implement io.Seeker`)
+			}
+			r.at, r.n = 0, 0
+			abs, err := sw.Seek(offset, io.SeekEnd)
+			r.pos = abs
+			return abs, err
+		default:
+			return 0, errors.New("custom.Reader.Seek: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("custom.Reader.Seek: negative position")
+	}
+	if start := r.pos - int64(r.at + r.n); abs >= start && abs <= r.pos { // seek within the buffer
+		at := int(abs - start)
+		r.at, r.n = at, r.at + r.n - at
+		return abs, nil
 	}
-	return 0, errors.New(`Does not implement io.Seeker`)
+	if !ok {
+		return 0, errors.New(`Does not implement io.Seeker`)
+	}
+	r.at, r.n = 0, 0
+	abs, err := sw.Seek(abs, io.SeekStart)
+	r.pos = abs
+	return abs, err
+}
+
+// Transfers the Reader to a new io.Reader, discarding anything left in the buffer. The pooled buffer is reused.
+// If this is a compressed reader then the decompressor is reset to read from the new io.Reader, reusing its state, and the new io.Reader must be compressed in the same format.
+// Parallel, seekable, checksummed and hashing readers are restarted on the new io.Reader, which must contain the same kind of stream. An encrypted reader returns ErrResetUnsupported, as the key is not kept.
+// Otherwise the new io.Reader is read directly. A closed Reader can be Reset and used again, but if Reset returns an error then the Reader can only be closed.
+func (r *Reader) Reset(newreader io.Reader) error {
+	r.at, r.n, r.pos, r.seeked = 0, 0, 0, false
+	if r.buf == nil {
+		r.buf = pool.Get().([]byte)
+	}
+	if l, ok := r.f.(readResetter); ok {
+		f, err := l.reset(newreader)
+		r.f = f
+		return err
+	}
+	if r.close {
+		switch z := r.f.(type) {
+			case zlib.Resetter:
+				return z.Reset(newreader, r.dict)
+			case *gzip.Reader:
+				return z.Reset(newreader)
+			case zstdReader:
+				return z.Reset(newreader)
+			case *snappy.Reader:
+				z.Reset(newreader)
+				return nil
+		}
+	}
+	r.f = newreader
+	return nil
 }
 
 // Checks whether the end of the underlying io.Reader has been reached. Returns nil if this is already the end. This is safe to do at any time whilst reading to check if the end is reached.
@@ -1861,9 +2697,21 @@ func (r *Reader) EOF() error {
 	if r.n > 0 {
 		return ErrNotEOF
 	}
-	m, err := r.f.Read(r.buf)
-	r.n = m
-	if err == io.EOF {
+	room := r.limits.room(r.pos, len(r.buf))
+	if room == 0 { // at MaxTotalBytes, so only the end is allowed
+		_, err := io.ReadFull(r.f, r.buf[0:1])
+		if err == io.EOF || err == ErrLimitExceeded {
+			return nil
+		}
+		if err == nil {
+			return r.limits.total(r.pos, 1)
+		}
+		return err
+	}
+	m, err := r.f.Read(r.buf[0:room])
+	r.at, r.n = 0, m
+	r.pos += int64(m)
+	if err == io.EOF || err == ErrLimitExceeded {
 		return nil
 	}
 	if err == nil {
@@ -1874,22 +2722,56 @@ func (r *Reader) EOF() error {
 
 // Releases the buffer back to the pool
 func (r *Reader) Close() error {
-	pool.Put(r.buf)
+	if !r.shared {
+		putBuffer(r.buf)
+	}
 	r.buf = nil
 	if r.close {
 		if sw, ok := r.f.(io.Closer); ok { // Attempt to close underlying reader if it has a Close() method
 			return sw.Close()
 		}
 	}
-	r.f = nil
+	if !r.restartable() { // anything Reset restarts is kept, so that a closed Reader can still be Reset
+		r.f = nil
+	}
 	return nil
 }
 
+// Whether Reset restarts what the custom.Reader reads from, rather than replacing it
+func (r *Reader) restartable() bool {
+	if _, ok := r.f.(readResetter); ok {
+		return true
+	}
+	if r.close {
+		switch r.f.(type) {
+			case zlib.Resetter, *gzip.Reader, zstdReader, *snappy.Reader:
+				return true
+		}
+	}
+	return false
+}
+
+// A layer beneath a custom.Reader which Reset restarts on a new io.Reader, stopping the old one. Returns what the custom.Reader should then read from, or nil with an error.
+type readResetter interface {
+	reset(f io.Reader) (io.Reader, error)
+}
+
+// Wraps zstd.Decoder so that it satisfies io.ReadCloser
+type zstdReader struct {
+	*zstd.Decoder
+}
+
+// Stops decoding the current stream, which releases its goroutine, but leaves the decoder open so that the custom.Reader can still be Reset after it is closed. An unused decoder is freed by the garbage collector.
+func (z zstdReader) Close() error {
+	return z.Decoder.Reset(nil)
+}
+
 // -------- BYTES READER --------
 
 type BytesReader struct {
 	data []byte
 	cursor, length int
+	limits *Limits	// set with SetLimits
 }
 
 // Creates a reader wrapping a slice of bytes
@@ -1914,6 +2796,8 @@ func (r *BytesReader) Read(p []byte) (n int, err error) {
 
 // Read x bytes and returns this slice of bytes as a copy
 func (r *BytesReader) Readx(x int) []byte {
+	r.check(x)
+	r.limits.alloc(x)
 	p := make([]byte, x)
 	r.cursor += copy(p, r.data[r.cursor:r.cursor+x])
 	return p
@@ -1921,6 +2805,7 @@ func (r *BytesReader) Readx(x int) []byte {
 
 // Returns a slice of the original. This slice is not a copy and so should not be modified
 func (r *BytesReader) ReadxRaw(x int) []byte {
+	r.check(x)
 	r.cursor += x
 	return r.data[r.cursor-x:r.cursor]
 }
@@ -2145,39 +3030,198 @@ func (r *BytesReader) ReadFloat64() float64 {
 
 // Read and decode a string encoded with WriteString8
 func (r *BytesReader) ReadString8() string {
-	return string(r.ReadxRaw(int(r.ReadByte())))
+	x := int(r.ReadByte())
+	r.limits.str(x)
+	return string(r.ReadxRaw(x))
 }
 
 // Read and decode a string encoded with WriteString16
 func (r *BytesReader) ReadString16() string {
-	return string(r.ReadxRaw(int(r.ReadUint16())))
+	x := int(r.ReadUint16())
+	r.limits.str(x)
+	return string(r.ReadxRaw(x))
 }
 
 // Read and decode a string encoded with WriteString32
 func (r *BytesReader) ReadString32() string {
-	return string(r.ReadxRaw(int(r.ReadUint32())))
+	x := int(r.ReadUint32())
+	r.limits.str(x)
+	return string(r.ReadxRaw(x))
+}
+
+// Read and decode a string encoded with WriteString8 without copying it. See ReadString32Unsafe.
+func (r *BytesReader) ReadString8Unsafe() string {
+	x := int(r.ReadByte())
+	r.limits.strLen(x)
+	return aliasString(r.ReadxRaw(x))
+}
+
+// Read and decode a string encoded with WriteString16 without copying it. See ReadString32Unsafe.
+func (r *BytesReader) ReadString16Unsafe() string {
+	x := int(r.ReadUint16())
+	r.limits.strLen(x)
+	return aliasString(r.ReadxRaw(x))
+}
+
+// Read and decode a string encoded with WriteString32 without copying it.
+// The string shares memory with the slice of bytes given to NewBytesReader, so it is only valid for as long as that memory is neither modified nor released (e.g. unmapped), and it must be copied to outlive it.
+// Build with -tags customdebug and call CheckAliases to detect writes to memory that such a string still refers to.
+func (r *BytesReader) ReadString32Unsafe() string {
+	x := int(r.ReadUint32())
+	r.limits.strLen(x)
+	return aliasString(r.ReadxRaw(x))
 }
 
 // Read and decode a string encoded with WriteString8 as slice of bytes
 func (r *BytesReader) ReadBytes8() []byte {
-	return r.Readx(int(r.ReadByte()))
+	x := int(r.ReadByte())
+	r.limits.str(x)
+	return r.Readx(x)
 }
 
 // Read and decode a string encoded with WriteString16 as slice of bytes
 func (r *BytesReader) ReadBytes16() []byte {
-	return r.Readx(int(r.ReadUint16()))
+	x := int(r.ReadUint16())
+	r.limits.str(x)
+	return r.Readx(x)
 }
 
 // Read and decode a string encoded with WriteString32 as slice of bytes
 func (r *BytesReader) ReadBytes32() []byte {
-	return r.Readx(int(r.ReadUint32()))
+	x := int(r.ReadUint32())
+	r.limits.str(x)
+	return r.Readx(x)
 }
 
-// Moves the cursor forward x bytes without returning anything
+// Returns the next n bytes without consuming them, or fewer if the end is reached first. This slice is not a copy and so should not be modified.
+func (r *BytesReader) Peek(n int) []byte {
+	if n < 0 {
+		panic(errors.New(`custom.BytesReader.Peek: negative count`))
+	}
+	if to := r.cursor + n; to < r.length {
+		return r.data[r.cursor:to:to]
+	}
+	if r.cursor >= r.length {
+		return nil
+	}
+	return r.data[r.cursor:r.length:r.length]
+}
+
+// Returns the next byte without consuming it
+func (r *BytesReader) PeekByte() uint8 {
+	return r.data[r.cursor]
+}
+
+// Steps back one byte, so that the last byte read is read again. Returns ErrUnread if the cursor is at the beginning.
+func (r *BytesReader) UnreadByte() error {
+	if r.cursor == 0 {
+		return ErrUnread
+	}
+	r.cursor--
+	return nil
+}
+
+// Steps back one rune, so that the rune read by the preceding ReadRune is read again. Returns ErrUnread if the cursor is at the beginning.
+func (r *BytesReader) UnreadRune() error {
+	if r.cursor == 0 {
+		return ErrUnread
+	}
+	rn, size := utf8.DecodeLastRune(r.data[0:r.cursor])
+	if rn == utf8.RuneError && size <= 1 {
+		return ErrUnread
+	}
+	r.cursor -= size
+	return nil
+}
+
+// Returns how many bytes are left to be read
+func (r *BytesReader) Buffered() int {
+	if r.cursor >= r.length {
+		return 0
+	}
+	return r.length - r.cursor
+}
+
+// Returns the position of the next byte to be read
+func (r *BytesReader) Offset() int64 {
+	return int64(r.cursor)
+}
+
+// Moves the cursor forward x bytes without returning anything. Fixed length types can be skipped with Discard, e.g. Discard(8) for a uint64.
+// Panics with io.ErrUnexpectedEOF if there are fewer than x bytes left, or if x is negative, so that a corrupt length cannot skip past the end.
 func (r *BytesReader) Discard(x int) {
+	r.check(x)
 	r.cursor += x
 }
 
+// Skip a string encoded with WriteString8 without reading it
+func (r *BytesReader) SkipString8() {
+	r.Discard(int(r.ReadByte()))
+}
+
+// Skip a string encoded with WriteString16 without reading it
+func (r *BytesReader) SkipString16() {
+	r.Discard(int(r.ReadUint16()))
+}
+
+// Skip a string encoded with WriteString32 without reading it
+func (r *BytesReader) SkipString32() {
+	r.Discard(int(r.ReadUint32()))
+}
+
+// Skip a slice of bytes encoded with WriteBytes8
+func (r *BytesReader) SkipBytes8() {
+	r.Discard(int(r.ReadByte()))
+}
+
+// Skip a slice of bytes encoded with WriteBytes16
+func (r *BytesReader) SkipBytes16() {
+	r.Discard(int(r.ReadUint16()))
+}
+
+// Skip a slice of bytes encoded with WriteBytes32
+func (r *BytesReader) SkipBytes32() {
+	r.Discard(int(r.ReadUint32()))
+}
+
+// Skip a uint16 encoded with WriteUint16Variable
+func (r *BytesReader) SkipUint16Variable() {
+	if r.ReadByte() == 255 {
+		r.Discard(2)
+	}
+}
+
+// Skip an int16 encoded with WriteInt16Variable
+func (r *BytesReader) SkipInt16Variable() {
+	if r.ReadByte() == 255 {
+		r.Discard(2)
+	}
+}
+
+// Skip a uint64 encoded with WriteUint64Variable
+func (r *BytesReader) SkipUint64Variable() {
+	r.Discard(int(r.ReadByte()))
+}
+
+// Skip 2 uint64s encoded with Write2Uint64sVariable
+func (r *BytesReader) Skip2Uint64sVariable() {
+	s := r.ReadByte()
+	r.Discard(int(s >> 4) + int(s & 15))
+}
+
+// Skip a UTF8 character or a rune encoded with WriteRune
+func (r *BytesReader) SkipUTF8() {
+	first := r.ReadByte()
+	if first < 128 { // length 1
+		return
+	}
+	if first & 32 == 0 { // length 2
+		r.Discard(1)
+	} else {
+		r.Discard(2)
+	}
+}
+
 // Implements io.Seeker (see io.Seeker for usage)
 func (r *BytesReader) Seek(offset int64, whence int) (int64, error) {
 	var abs int64
@@ -0,0 +1,129 @@
+package custom
+
+import (
+ "container/heap"
+ "hash/crc32"
+)
+
+const (
+	dictKmer = 8 // length of the substrings counted when training a dictionary
+	dictSegment = 64 // length of the segments of sample data which make up a dictionary
+	dictDefaultLen = 32768 // the window size of Zlib, which cannot use more dictionary than this
+)
+
+// -------- DICTIONARY --------
+
+// Trains a preset dictionary of at most size bytes from sample data, for use with NewZlibWriterDict and NewZstdWriterDict.
+// The samples should be typical of the small payloads that will be compressed. Segments of the samples which contain the substrings most common across the samples are selected, with the most valuable placed at the end of the dictionary where they are cheapest to reference.
+// If size <= 0 then 32 KiB is used, which is the most that Zlib is able to make use of.
+func TrainDictionary(samples []*Buffer, size int) []byte {
+	if size <= 0 {
+		size = dictDefaultLen
+	}
+	// Count the number of samples in which each k-mer appears
+	counts := make(map[uint64]int)
+	seen := make(map[uint64]bool)
+	for _, sample := range samples {
+		b := sample.Bytes()
+		for i := 0; i + dictKmer <= len(b); i++ {
+			k := kmer(b[i:])
+			if !seen[k] {
+				seen[k] = true
+				counts[k]++
+			}
+		}
+		for k := range seen {
+			delete(seen, k)
+		}
+	}
+	min := 2
+	if len(samples) < 2 {
+		min = 1
+	}
+	// Score every candidate segment
+	var h dictHeap
+	for i, sample := range samples {
+		b := sample.Bytes()
+		for at := 0; at < len(b); at += dictSegment / 2 {
+			end := at + dictSegment
+			if end > len(b) {
+				end = len(b)
+			}
+			seg := dictCandidate{sample: i, at: at, end: end}
+			if seg.score = seg.rescore(samples, counts, min); seg.score > 0 {
+				h = append(h, seg)
+			}
+		}
+	}
+	heap.Init(&h)
+	// Greedily take the best segments, rescoring lazily as the k-mers they contain are used up
+	var chosen []dictCandidate
+	var total int
+	for len(h) > 0 && total < size {
+		seg := heap.Pop(&h).(dictCandidate)
+		if seg.score = seg.rescore(samples, counts, min); seg.score == 0 {
+			continue
+		}
+		if len(h) > 0 && seg.score < h[0].score {
+			heap.Push(&h, seg)
+			continue
+		}
+		b := samples[seg.sample].Bytes()[seg.at:seg.end]
+		for i := 0; i + dictKmer <= len(b); i++ {
+			delete(counts, kmer(b[i:]))
+		}
+		if total + len(b) > size {
+			seg.at = seg.end - (size - total)
+			b = b[len(b) - (size - total):]
+		}
+		chosen = append(chosen, seg)
+		total += len(b)
+	}
+	// The first chosen segment is the most valuable, so it goes last
+	dict := make([]byte, 0, total)
+	for i := len(chosen) - 1; i >= 0; i-- {
+		seg := chosen[i]
+		dict = append(dict, samples[seg.sample].Bytes()[seg.at:seg.end]...)
+	}
+	return dict
+}
+
+// Returns the ID under which a raw dictionary is registered with Zstandard. It is derived from the content so that a mismatched dictionary is detected when reading.
+func dictID(dict []byte) uint32 {
+	if id := crc32.ChecksumIEEE(dict); id != 0 {
+		return id
+	}
+	return 1
+}
+
+func kmer(b []byte) uint64 {
+	return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 | uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56
+}
+
+type dictCandidate struct {
+	sample, at, end, score int
+}
+
+func (seg dictCandidate) rescore(samples []*Buffer, counts map[uint64]int, min int) (score int) {
+	b := samples[seg.sample].Bytes()[seg.at:seg.end]
+	for i := 0; i + dictKmer <= len(b); i++ {
+		if c := counts[kmer(b[i:])]; c >= min {
+			score += c
+		}
+	}
+	return
+}
+
+// A max-heap of candidate segments by score, implementing heap.Interface
+type dictHeap []dictCandidate
+
+func (h dictHeap) Len() int { return len(h) }
+func (h dictHeap) Less(i, j int) bool { return h[i].score > h[j].score }
+func (h dictHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *dictHeap) Push(x interface{}) { *h = append(*h, x.(dictCandidate)) }
+func (h *dictHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[0:len(old)-1]
+	return x
+}
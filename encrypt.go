@@ -0,0 +1,260 @@
+package custom
+
+import (
+ "crypto/aes"
+ "crypto/cipher"
+ "crypto/rand"
+ "crypto/sha256"
+ "errors"
+ "io"
+ "golang.org/x/crypto/chacha20poly1305"
+ "golang.org/x/crypto/hkdf"
+)
+
+// An encrypted stream begins with a header of encryptMagic, the Cipher as 1 byte, the Codec as 1 byte, a random salt and a random nonce prefix.
+// The segments are not sealed with the caller's key but with a key for this stream alone, derived from it and the salt with HKDF-SHA256, so that nonces cannot repeat across streams however many are written with the same key.
+// The plaintext (compressed first if a Codec is given) is then split into segments of segmentLen bytes, each sealed with the AEAD, following the STREAM construction:
+// the nonce of each segment is the nonce prefix, the segment number as a big-endian uint32, and 1 byte which is 1 for the final segment and 0 otherwise.
+// The header is the associated data of every segment, so it cannot be altered, and segments cannot be reordered, dropped or truncated without detection.
+const (
+	encryptMagic = "CENC"
+	saltLen = 32
+	noncePrefixLen = 7
+	encryptHeaderLen = len(encryptMagic) + 2 + saltLen + noncePrefixLen
+	segmentLen = bufferLen
+	KeyLen = 32
+)
+
+// The authenticated encryption used by an encrypted stream
+type Cipher uint8
+
+const (
+	CipherAES256GCM Cipher = iota
+	CipherChaCha20Poly1305
+)
+
+var ErrUnknownCipher = errors.New(`Unknown cipher`)
+var ErrInvalidKey = errors.New(`Key must be 32 bytes`)
+var ErrDecrypt = errors.New(`Decryption failed: the data is corrupt or the key is wrong`)
+var ErrTooManySegments = errors.New(`Too many segments`)
+
+func (c Cipher) aead(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeyLen {
+		return nil, ErrInvalidKey
+	}
+	switch c {
+		case CipherAES256GCM:
+			block, err := aes.NewCipher(key)
+			if err != nil {
+				return nil, err
+			}
+			return cipher.NewGCM(block)
+		case CipherChaCha20Poly1305:
+			return chacha20poly1305.New(key)
+	}
+	return nil, ErrUnknownCipher
+}
+
+// Derives the key for one stream from the caller's key and the salt in its header, binding it to the magic, Cipher and Codec too
+func (c Cipher) streamAEAD(key, header []byte) (cipher.AEAD, error) {
+	if len(key) != KeyLen {
+		return nil, ErrInvalidKey
+	}
+	fixed := len(encryptMagic) + 2
+	subkey := make([]byte, KeyLen)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, header[fixed:fixed + saltLen], header[0:fixed]), subkey); err != nil {
+		return nil, err
+	}
+	return c.aead(subkey)
+}
+
+// -------- ENCRYPTED WRITER --------
+
+type encryptWriter struct {
+	w io.Writer
+	aead cipher.AEAD
+	header, nonce []byte
+	counter uint32
+	seg, out []byte
+	err error
+}
+
+// Creates a new buffered writer wrapping an io.Writer, which encrypts everything written with a 32 byte key. The custom.Writer must be closed to seal the final segment.
+func NewEncryptedWriter(f io.Writer, key []byte, c Cipher) (*Writer, error) {
+	return NewEncryptedCompressedWriter(f, key, c, CodecNone)
+}
+
+// Creates a new buffered writer wrapping an io.Writer, which compresses with codec and then encrypts with a 32 byte key. The custom.Writer must be closed to finish both streams.
+// NewEncryptedReader detects the codec from the header.
+func NewEncryptedCompressedWriter(f io.Writer, key []byte, c Cipher, codec Codec) (*Writer, error) {
+	if len(key) != KeyLen {
+		return nil, ErrInvalidKey
+	}
+	header := append(append([]byte(encryptMagic), byte(c), byte(codec)), make([]byte, saltLen + noncePrefixLen)...)
+	if _, err := rand.Read(header[len(encryptMagic) + 2:]); err != nil {
+		return nil, err
+	}
+	aead, err := c.streamAEAD(key, header)
+	if err != nil {
+		return nil, err
+	}
+	ew := &encryptWriter{w: f, aead: aead, header: header, seg: make([]byte, 0, segmentLen), nonce: make([]byte, aead.NonceSize())}
+	copy(ew.nonce, ew.header[encryptHeaderLen - noncePrefixLen:])
+	if _, err = f.Write(ew.header); err != nil {
+		return nil, err
+	}
+	if codec == CodecNone {
+		return &Writer{w: ew, data: pool.Get().([]byte), close: true, under: f}, nil
+	}
+	z, err := codec.newWriter(ew)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{w: writeChain{z, ew}, data: pool.Get().([]byte), close: true, under: f}, nil
+}
+
+// Seals the pending segment and writes it out
+func (ew *encryptWriter) seal(final bool) error {
+	if ew.err != nil {
+		return ew.err
+	}
+	n := len(ew.nonce)
+	ew.nonce[n-5], ew.nonce[n-4], ew.nonce[n-3], ew.nonce[n-2] = byte(ew.counter >> 24), byte(ew.counter >> 16), byte(ew.counter >> 8), byte(ew.counter)
+	if final {
+		ew.nonce[n-1] = 1
+	}
+	ew.out = ew.aead.Seal(ew.out[0:0], ew.nonce, ew.seg, ew.header)
+	ew.seg = ew.seg[0:0]
+	if _, ew.err = ew.w.Write(ew.out); ew.err != nil {
+		return ew.err
+	}
+	if ew.counter++; ew.counter == 0 {
+		ew.err = ErrTooManySegments
+	}
+	return ew.err
+}
+
+func (ew *encryptWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if len(ew.seg) == segmentLen { // only sealed once more data arrives, because the final segment must be marked
+			if err = ew.seal(false); err != nil {
+				return
+			}
+		}
+		l := copy(ew.seg[len(ew.seg):segmentLen], p)
+		ew.seg = ew.seg[0:len(ew.seg) + l]
+		p = p[l:]
+		n += l
+	}
+	return
+}
+
+// Seals the final segment. The underlying io.Writer is not closed.
+func (ew *encryptWriter) Close() error {
+	return ew.seal(true)
+}
+
+// -------- ENCRYPTED READER --------
+
+type decryptReader struct {
+	r io.Reader
+	aead cipher.AEAD
+	header, nonce []byte
+	counter uint32
+	ct []byte	// room for a whole sealed segment plus 1 byte, which is read ahead to find out whether the segment is the final one
+	have int	// how many bytes of the next segment are already in ct
+	plain []byte	// the decrypted segment
+	pt []byte	// the unread part of the decrypted segment
+	err error
+}
+
+// Creates a new buffered reader wrapping an io.Reader which contains a stream written by NewEncryptedWriter or NewEncryptedCompressedWriter, decrypting with a 32 byte key.
+// Every segment is authenticated before any of it is read, and reads fail with ErrDecrypt if it has been altered.
+func NewEncryptedReader(f io.Reader, key []byte) (*Reader, error) {
+	header := make([]byte, encryptHeaderLen)
+	if _, err := io.ReadFull(f, header); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if string(header[0:len(encryptMagic)]) != encryptMagic {
+		return nil, ErrInvalidHeader
+	}
+	aead, err := Cipher(header[len(encryptMagic)]).streamAEAD(key, header)
+	if err != nil {
+		return nil, err
+	}
+	codec := Codec(header[len(encryptMagic) + 1])
+	if !codec.valid() {
+		return nil, ErrUnknownCodec
+	}
+	dr := &decryptReader{r: f, aead: aead, header: header, nonce: make([]byte, aead.NonceSize()), ct: make([]byte, segmentLen + aead.Overhead() + 1), plain: make([]byte, 0, segmentLen)}
+	copy(dr.nonce, header[encryptHeaderLen - noncePrefixLen:])
+	z, err := codec.newReader(dr)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{f: decryptTop{z}, buf: pool.Get().([]byte), close: true}, nil
+}
+
+// The top of an encrypted reader: the decompressor, or the decryptReader itself if there is no codec
+type decryptTop struct {
+	io.Reader
+}
+
+func (d decryptTop) Close() error {
+	if c, ok := d.Reader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// The key is not kept, so an encrypted reader cannot be restarted on another stream
+func (d decryptTop) reset(f io.Reader) (io.Reader, error) {
+	d.Close()
+	return nil, ErrResetUnsupported
+}
+
+// Reads and opens the next segment
+func (dr *decryptReader) next() error {
+	m, err := io.ReadFull(dr.r, dr.ct[dr.have:])
+	total := dr.have + m
+	final := err != nil
+	if final && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	seg := dr.ct[0:total]
+	if !final {
+		seg = dr.ct[0:total-1]
+	}
+	n := len(dr.nonce)
+	dr.nonce[n-5], dr.nonce[n-4], dr.nonce[n-3], dr.nonce[n-2] = byte(dr.counter >> 24), byte(dr.counter >> 16), byte(dr.counter >> 8), byte(dr.counter)
+	if final {
+		dr.nonce[n-1] = 1
+	}
+	pt, err := dr.aead.Open(dr.plain[0:0], dr.nonce, seg, dr.header)
+	if err != nil {
+		return ErrDecrypt
+	}
+	dr.pt = pt
+	if final {
+		return io.EOF
+	}
+	dr.counter++
+	dr.ct[0] = dr.ct[total-1] // the byte read ahead belongs to the next segment
+	dr.have = 1
+	return nil
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.pt) == 0 {
+		if dr.err != nil {
+			return 0, dr.err
+		}
+		dr.err = dr.next()
+	}
+	n := copy(p, dr.pt)
+	dr.pt = dr.pt[n:]
+	return n, nil
+}
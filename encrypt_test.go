@@ -0,0 +1,120 @@
+package custom
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+var testKey = []byte(`0123456789abcdef0123456789abcdef`)
+
+// Writes n uint32s through an encrypted writer and returns the stream
+func encryptTestStream(t *testing.T, c Cipher, codec Codec, n int) []byte {
+	var b bytes.Buffer
+	w, err := NewEncryptedCompressedWriter(&b, testKey, c, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		w.WriteUint32(uint32(i))
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// Reads the whole of an encrypted stream and returns the plaintext and the first error other than io.EOF
+func decryptTestStream(data, key []byte) ([]byte, error) {
+	r, err := NewEncryptedReader(bytes.NewReader(data), key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	for _, c := range []Cipher{CipherAES256GCM, CipherChaCha20Poly1305} {
+		for _, codec := range []Codec{CodecNone, CodecZlib} {
+			for _, n := range []int{0, 1, segmentLen / 4, segmentLen} {
+				data := encryptTestStream(t, c, codec, n)
+				r, err := NewEncryptedReader(bytes.NewReader(data), testKey)
+				if err != nil {
+					t.Fatal(c, codec, n, err)
+				}
+				for i := 0; i < n; i++ {
+					if v := r.ReadUint32(); v != uint32(i) {
+						t.Fatalf(`cipher %d codec %d: value %d read as %d`, c, codec, i, v)
+					}
+				}
+				if err = r.EOF(); err != nil {
+					t.Fatal(c, codec, n, err)
+				}
+				r.Close()
+			}
+		}
+	}
+}
+
+func TestEncryptStreamsDiffer(t *testing.T) {
+	a := encryptTestStream(t, CipherAES256GCM, CodecNone, 100)
+	b := encryptTestStream(t, CipherAES256GCM, CodecNone, 100)
+	if bytes.Equal(a[len(encryptMagic)+2:encryptHeaderLen], b[len(encryptMagic)+2:encryptHeaderLen]) {
+		t.Fatal(`two streams have the same salt and nonce prefix`)
+	}
+	if bytes.Equal(a[encryptHeaderLen:], b[encryptHeaderLen:]) {
+		t.Fatal(`the same plaintext encrypted twice gives the same ciphertext`)
+	}
+}
+
+func TestEncryptWrongKey(t *testing.T) {
+	data := encryptTestStream(t, CipherChaCha20Poly1305, CodecNone, 100)
+	key := append([]byte(nil), testKey...)
+	key[0] ^= 1
+	if _, err := decryptTestStream(data, key); err != ErrDecrypt {
+		t.Fatal(`expected ErrDecrypt, got`, err)
+	}
+	if _, err := NewEncryptedReader(bytes.NewReader(data), key[1:]); err != ErrInvalidKey {
+		t.Fatal(`expected ErrInvalidKey, got`, err)
+	}
+}
+
+func TestEncryptTamper(t *testing.T) {
+	for _, c := range []Cipher{CipherAES256GCM, CipherChaCha20Poly1305} {
+		data := encryptTestStream(t, c, CodecNone, segmentLen/2) // two segments
+		for _, i := range []int{len(encryptMagic) + 2, encryptHeaderLen - 1, encryptHeaderLen, encryptHeaderLen + segmentLen + 10, len(data) - 1} {
+			bad := append([]byte(nil), data...)
+			bad[i] ^= 1
+			if _, err := decryptTestStream(bad, testKey); err != ErrDecrypt {
+				t.Fatalf(`cipher %d: byte %d flipped: expected ErrDecrypt, got %v`, c, i, err)
+			}
+		}
+		// the codec byte is authenticated too
+		bad := append([]byte(nil), data...)
+		bad[len(encryptMagic)+1] = byte(CodecZlib)
+		if _, err := decryptTestStream(bad, testKey); err == nil {
+			t.Fatalf(`cipher %d: changed codec was not detected`, c)
+		}
+	}
+}
+
+func TestEncryptTruncate(t *testing.T) {
+	data := encryptTestStream(t, CipherAES256GCM, CodecNone, segmentLen/4*3) // three full segments
+	seg := segmentLen + 16
+	if len(data) != encryptHeaderLen+3*seg {
+		t.Fatal(`unexpected stream length`, len(data))
+	}
+	for _, n := range []int{encryptHeaderLen, encryptHeaderLen + seg, encryptHeaderLen + 2*seg, encryptHeaderLen + 2*seg + 100, len(data) - 1} {
+		if _, err := decryptTestStream(data[0:n], testKey); err != ErrDecrypt {
+			t.Fatalf(`truncated to %d bytes: expected ErrDecrypt, got %v`, n, err)
+		}
+	}
+	if _, err := decryptTestStream(data[0:encryptHeaderLen-1], testKey); err != io.ErrUnexpectedEOF {
+		t.Fatal(`truncated header: expected io.ErrUnexpectedEOF, got`, err)
+	}
+	// an extra segment cannot be appended after the final one
+	if _, err := decryptTestStream(append(append([]byte(nil), data...), data[encryptHeaderLen:encryptHeaderLen+seg]...), testKey); err != ErrDecrypt {
+		t.Fatal(`extended stream: expected ErrDecrypt, got`, err)
+	}
+}
@@ -0,0 +1,87 @@
+package custom
+
+import (
+ "time"
+)
+
+// When a custom.Writer syncs what it has written to stable storage, set with SetSyncPolicy. Zero fields are ignored.
+// Bytes and Interval are checked on every write, and when either is reached the buffer is written out along with any layer beneath it and then synced.
+// Nothing runs in the background, so what is written just before the custom.Writer falls idle is not synced until the next write, Sync or Close.
+type SyncPolicy struct {
+	Bytes int64	// sync once this many bytes have been written since the last sync, counting those still in the buffer
+	Interval time.Duration	// sync on the first write this long or more after the last sync
+	OnClose bool	// sync when the custom.Writer is closed
+}
+
+type flusher interface {
+	Flush() error
+}
+
+type syncer interface {
+	Sync() error
+}
+
+// Flushes the buffer, then any compressor or other layer beneath it, and then calls Sync on the underlying io.Writer if it has one (e.g. *os.File), so that everything written so far survives a crash or power loss.
+// An encrypted writer can only flush whole segments, so the end of the last segment is not synced until the custom.Writer is closed.
+func (w *Writer) Sync() error {
+	if w.cursor > 0 {
+		n, err := w.w.Write(w.data[0:w.cursor])
+		w.offset += int64(n)
+		w.cursor = 0
+		if err != nil {
+			return err
+		}
+	}
+	return w.syncBelow()
+}
+
+// Sets when the custom.Writer syncs by itself, so that an append-only log written with it survives power loss. A zero SyncPolicy turns this off.
+func (w *Writer) SetSyncPolicy(p SyncPolicy) {
+	if p == (SyncPolicy{}) {
+		w.policy = nil
+		return
+	}
+	w.policy = &p
+	w.unsynced = 0
+	w.synced = time.Now()
+}
+
+// Syncs if the policy says so, after n more bytes have been written out
+func (w *Writer) applyPolicy(n int) error {
+	w.unsynced += int64(n)
+	if (w.policy.Bytes > 0 && w.unsynced >= w.policy.Bytes) || (w.policy.Interval > 0 && time.Since(w.synced) >= w.policy.Interval) {
+		return w.syncBelow()
+	}
+	return nil
+}
+
+// Syncs everything, including the buffer, if the policy says so after a write into the buffer
+func (w *Writer) checkPolicy() error {
+	if (w.policy.Bytes > 0 && w.unsynced + int64(w.cursor) >= w.policy.Bytes) || (w.policy.Interval > 0 && time.Since(w.synced) >= w.policy.Interval) {
+		return w.Sync()
+	}
+	return nil
+}
+
+// Flushes the layer beneath the buffer and syncs the underlying io.Writer
+func (w *Writer) syncBelow() error {
+	if f, ok := w.w.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+	target := w.under
+	if target == nil {
+		target = w.w
+	}
+	if s, ok := target.(syncer); ok {
+		if err := s.Sync(); err != nil {
+			return err
+		}
+	}
+	w.unsynced = 0
+	if w.policy != nil && w.policy.Interval > 0 {
+		w.synced = time.Now()
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package custom
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// Records what has been written and how many times it was synced, and how much had been written at the last sync
+type syncRecorder struct {
+	bytes.Buffer
+	syncs int
+	synced int
+}
+
+func (s *syncRecorder) Sync() error {
+	s.syncs++
+	s.synced = s.Len()
+	return nil
+}
+
+func TestSyncPolicyBytes(t *testing.T) {
+	s := &syncRecorder{}
+	w := NewWriter(s)
+	w.SetSyncPolicy(SyncPolicy{Bytes: 100})
+	for i := 0; i < 24; i++ { // 96 bytes, all still in the buffer
+		w.WriteUint32(uint32(i))
+	}
+	if s.syncs != 0 {
+		t.Fatal(`synced before Bytes was reached`)
+	}
+	w.WriteUint32(24)
+	if s.syncs != 1 || s.synced != 100 {
+		t.Fatalf(`after 100 bytes: %d syncs of %d bytes`, s.syncs, s.synced)
+	}
+	w.Write(make([]byte, 99))
+	if s.syncs != 1 {
+		t.Fatal(`synced again before another 100 bytes`)
+	}
+	w.WriteByte(0)
+	if s.syncs != 2 || s.synced != 200 {
+		t.Fatalf(`after 200 bytes: %d syncs of %d bytes`, s.syncs, s.synced)
+	}
+	w.Write(make([]byte, 3 * bufferLen)) // written out directly
+	if s.syncs != 3 || s.synced != 200 + 3 * bufferLen {
+		t.Fatalf(`after a large write: %d syncs of %d bytes`, s.syncs, s.synced)
+	}
+	w.Close()
+}
+
+func TestSyncPolicyInterval(t *testing.T) {
+	s := &syncRecorder{}
+	w := NewWriter(s)
+	w.SetSyncPolicy(SyncPolicy{Interval: 20 * time.Millisecond})
+	w.WriteString(`first record`)
+	if s.syncs != 0 {
+		t.Fatal(`synced before Interval had passed`)
+	}
+	time.Sleep(30 * time.Millisecond)
+	w.WriteString(`second record`)
+	if s.syncs != 1 || s.String() != `first recordsecond record` {
+		t.Fatalf(`after Interval: %d syncs of %q`, s.syncs, s.String())
+	}
+	w.WriteByte('!')
+	if s.syncs != 1 {
+		t.Fatal(`synced again before Interval had passed`)
+	}
+	time.Sleep(30 * time.Millisecond)
+	w.Write2Bytes('!', '!')
+	if s.syncs != 2 || s.synced != 28 {
+		t.Fatalf(`after a second Interval: %d syncs of %d bytes`, s.syncs, s.synced)
+	}
+	w.Close()
+}
+
+func TestSyncPolicyOnClose(t *testing.T) {
+	s := &syncRecorder{}
+	w := NewWriter(s)
+	w.SetSyncPolicy(SyncPolicy{OnClose: true})
+	w.WriteString(`record`)
+	if s.syncs != 0 {
+		t.Fatal(`synced before Close`)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s.syncs != 1 || s.synced != 6 {
+		t.Fatalf(`after Close: %d syncs of %d bytes`, s.syncs, s.synced)
+	}
+	s = &syncRecorder{}
+	w = NewWriter(s)
+	w.SetSyncPolicy(SyncPolicy{Bytes: 10})
+	w.SetSyncPolicy(SyncPolicy{}) // turned off again
+	w.Write(make([]byte, 100))
+	w.Close()
+	if s.syncs != 0 {
+		t.Fatal(`synced with no policy`)
+	}
+}
+
+// Sync flushes the compressor, so that what is synced can be read back before the stream is closed
+func TestSyncCompressed(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), `sync`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := NewZlibWriter(f)
+	w.WriteString(`hello`)
+	if err = w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	g, err := os.Open(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	r := NewZlibReader(g)
+	if got := string(r.Readx(5)); got != `hello` {
+		t.Fatal(`read back`, got)
+	}
+	w.Close()
+
+	s := &syncRecorder{}
+	w = NewParallelCompressedWriter(s, CodecSnappy, 1000, 2)
+	w.Write(bytes.Repeat([]byte(`abc`), 1000))
+	w.WriteString(`tail`)
+	if err = w.Sync(); err != nil || s.syncs != 1 {
+		t.Fatal(err, s.syncs)
+	}
+	got := make([]byte, 3004)
+	if _, err = io.ReadFull(NewParallelReader(bytes.NewReader(s.Bytes()), 1), got); err != nil || string(got[3000:]) != `tail` {
+		t.Fatal(`read back`, err)
+	}
+	w.Close()
+}
@@ -0,0 +1,71 @@
+package custom
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBufferGrowth(t *testing.T) {
+	b := NewBuffer(0)
+	if b.Cap() != bufferLen {
+		t.Fatal(`a new Buffer holds`, b.Cap(), `bytes`)
+	}
+	var want []byte
+	for i := 0; i < 300000; i++ {
+		b.WriteByte(byte(i))
+		want = append(want, byte(i))
+	}
+	if !bytes.Equal(b.Bytes(), want) || b.Cap() != 1 << 19 {
+		t.Fatal(`after growing the Buffer holds`, b.Cap(), `bytes`)
+	}
+	b.Write(sectionTestData(maxBufferLen)) // beyond the largest pool
+	want = append(want, sectionTestData(maxBufferLen)...)
+	if !bytes.Equal(b.Bytes(), want) || b.Cap() < len(want) {
+		t.Fatal(`after growing beyond the pools the Buffer holds`, b.Cap(), `bytes`)
+	}
+	b.Close()
+	b.Close()
+	if NewBuffer(100000).Cap() != 131072 {
+		t.Fatal(`NewBuffer(100000) holds`, NewBuffer(100000).Cap(), `bytes`)
+	}
+}
+
+func TestBufferGrow(t *testing.T) {
+	b := NewBuffer(0)
+	b.WriteString(`abc`)
+	b.Grow(1 << 20)
+	if b.Cap() < 3 + 1 << 20 || b.String() != `abc` {
+		t.Fatal(`after Grow the Buffer holds`, b.Cap(), `bytes`)
+	}
+	c := b.Cap()
+	b.Grow(10)
+	b.Grow(0)
+	if b.Cap() != c {
+		t.Fatal(`Grow with room to spare grew the Buffer`)
+	}
+	b.Write(make([]byte, c - 3))
+	if b.Cap() != c {
+		t.Fatal(`writing what Grow made room for grew the Buffer`)
+	}
+	if e := catchPanic(func() { b.Grow(-1) }); e == nil {
+		t.Fatal(`Grow(-1) did not panic`)
+	}
+	b.Close()
+}
+
+// The zero Buffer starts with the smallest pooled buffer
+func TestBufferZero(t *testing.T) {
+	var b Buffer
+	if b.Cap() != 0 || b.Len() != 0 {
+		t.Fatal(`the zero Buffer holds`, b.Cap(), `bytes`)
+	}
+	b.WriteString(`hi`)
+	if b.String() != `hi` || b.Cap() != minBufferLen {
+		t.Fatal(`the zero Buffer grew to`, b.Cap(), `bytes`)
+	}
+	var g Buffer
+	g.Grow(100000)
+	if g.Cap() != 131072 {
+		t.Fatal(`Grow on the zero Buffer grew to`, g.Cap(), `bytes`)
+	}
+}
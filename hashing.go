@@ -0,0 +1,72 @@
+package custom
+
+import (
+ "hash"
+ "io"
+)
+
+// -------- HASHING WRITER --------
+
+// Feeds everything written to the underlying io.Writer into a hash, straight from the buffer being flushed
+type hashWriter struct {
+	w io.Writer
+	h hash.Hash
+}
+
+// Creates a new buffered writer wrapping an io.Writer, which also computes the hash of everything written to the io.Writer. Use Sum to retrieve it.
+func NewHashingWriter(f io.Writer, h hash.Hash) *Writer {
+	return &Writer{w: &hashWriter{w: f, h: h}, data: pool.Get().([]byte), h: h, under: f}
+}
+
+func (hw *hashWriter) Write(p []byte) (int, error) {
+	n, err := hw.w.Write(p)
+	hw.h.Write(p[0:n])
+	return n, err
+}
+
+// Appends the hash of everything written so far to b and returns it, flushing the buffer first if the custom.Writer is not yet closed.
+// Returns nil if this custom.Writer was not created with NewHashingWriter.
+func (w *Writer) Sum(b []byte) []byte {
+	if w.h == nil {
+		return nil
+	}
+	if w.w != nil && w.cursor > 0 {
+		w.Flush()
+	}
+	return w.h.Sum(b)
+}
+
+// -------- HASHING READER --------
+
+// Feeds everything read from the underlying io.Reader into a hash, straight from the buffer being filled
+type hashReader struct {
+	r io.Reader
+	h hash.Hash
+}
+
+// Creates a new buffered reader wrapping an io.Reader, which also computes the hash of everything read from the io.Reader. Use Sum to retrieve it.
+func NewHashingReader(f io.Reader, h hash.Hash) *Reader {
+	return &Reader{f: &hashReader{r: f, h: h}, buf: pool.Get().([]byte), h: h}
+}
+
+func (hr *hashReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	hr.h.Write(p[0:n])
+	return n, err
+}
+
+// Starts hashing f from the beginning
+func (hr *hashReader) reset(f io.Reader) (io.Reader, error) {
+	hr.h.Reset()
+	hr.r = f
+	return hr, nil
+}
+
+// Appends the hash of everything read so far from the underlying io.Reader to b and returns it. This includes data that is buffered but not yet consumed, and so is the hash of the whole stream once EOF returns nil.
+// Returns nil if this custom.Reader was not created with NewHashingReader.
+func (r *Reader) Sum(b []byte) []byte {
+	if r.h == nil {
+		return nil
+	}
+	return r.h.Sum(b)
+}
@@ -0,0 +1,66 @@
+package custom
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash/crc32"
+	"testing"
+)
+
+func TestHashingRoundTrip(t *testing.T) {
+	var b bytes.Buffer
+	w := NewHashingWriter(&b, sha256.New())
+	for i := 0; i < 100000; i++ {
+		w.WriteUint64Variable(uint64(i))
+	}
+	w.Write(make([]byte, 100000))
+	mid := w.Sum(nil) // flushes, so this is the hash of everything so far
+	if want := sha256.Sum256(b.Bytes()); !bytes.Equal(mid, want[:]) {
+		t.Fatal(`Sum before Close does not include the buffer`)
+	}
+	w.WriteString(`end`)
+	w.Close()
+	want := sha256.Sum256(b.Bytes())
+	if !bytes.Equal(w.Sum(nil), want[:]) {
+		t.Fatal(`writer hash is wrong`)
+	}
+	r := NewHashingReader(bytes.NewReader(b.Bytes()), sha256.New())
+	for i := 0; i < 100000; i++ {
+		if v := r.ReadUint64Variable(); v != uint64(i) {
+			t.Fatalf(`value %d read as %d`, i, v)
+		}
+	}
+	r.Readx(100003)
+	if err := r.EOF(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r.Sum(nil), want[:]) {
+		t.Fatal(`reader hash is wrong`)
+	}
+	if NewWriter(&b).Sum(nil) != nil || NewReader(&b).Sum(nil) != nil {
+		t.Fatal(`Sum is not nil without a hash`)
+	}
+}
+
+// Reset starts a new hash for the new io.Writer
+func TestHashingWriterReset(t *testing.T) {
+	var one, two bytes.Buffer
+	w := NewHashingWriter(&one, crc32.NewIEEE())
+	w.WriteString(`the first stream`)
+	if err := w.Reset(&two); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString(`the second stream`)
+	w.Close()
+	if one.String() != `the first stream` || two.String() != `the second stream` {
+		t.Fatalf(`wrote %q and %q`, one.String(), two.String())
+	}
+	if got, want := w.Sum(nil), crc32.NewIEEE().Sum(nil); bytes.Equal(got, want) {
+		t.Fatal(`nothing was hashed after Reset`)
+	}
+	h := crc32.NewIEEE()
+	h.Write(two.Bytes())
+	if !bytes.Equal(w.Sum(nil), h.Sum(nil)) {
+		t.Fatal(`the hash after Reset includes the first stream`)
+	}
+}
@@ -0,0 +1,109 @@
+package custom
+
+import (
+ "io"
+ "strconv"
+)
+
+// -------- LIMITS --------
+
+// Limits on what a Reader or BytesReader will read, for decoding untrusted input. A zero field means no limit.
+type Limits struct {
+	MaxAlloc int	// the largest slice of bytes or string that will be allocated in one go, e.g. by Readx or ReadBytes32
+	MaxStringLen int	// the longest string or slice of bytes that ReadString8/16/32 and ReadBytes8/16/32 will accept
+	MaxTotalBytes int64	// the position, as given by Offset, beyond which nothing will be read
+}
+
+// Panicked (or returned, for methods with an error) when reading would break one of the Limits given to SetLimits
+type ErrLimitViolation struct {
+	Limit string	// the name of the field of Limits which would be broken
+	Requested int64	// the size or position that the read asked for
+	Max int64	// the value of the limit
+}
+
+func (e *ErrLimitViolation) Error() string {
+	return `Limit ` + e.Limit + ` of ` + strconv.FormatInt(e.Max, 10) + ` exceeded: ` + strconv.FormatInt(e.Requested, 10) + ` requested`
+}
+
+// Sets limits which are checked before anything is allocated or read, so that a corrupt or malicious length cannot exhaust memory. Sections created with Limit inherit them. A parallel reader checks its block size against MaxAlloc when it is first read, so SetLimits must be called before that.
+func (r *Reader) SetLimits(l Limits) {
+	if l == (Limits{}) {
+		r.limits = nil
+	} else {
+		r.limits = &l
+	}
+	if s, ok := r.f.(limitSetter); ok {
+		s.setLimits(r.limits)
+	}
+}
+
+// A layer beneath a custom.Reader which allocates according to what it reads, and so needs the Limits too
+type limitSetter interface {
+	setLimits(l *Limits)
+}
+
+// Sets limits which are checked before anything is allocated or resliced, so that a corrupt or malicious length cannot exhaust memory. Slices created with Slice inherit them.
+func (r *BytesReader) SetLimits(l Limits) {
+	if l == (Limits{}) {
+		r.limits = nil
+		return
+	}
+	r.limits = &l
+}
+
+// Panics if allocating x bytes would break MaxAlloc
+func (l *Limits) alloc(x int) {
+	if err := l.checkAlloc(x); err != nil {
+		panic(err)
+	}
+}
+
+// Returns an error if allocating x bytes would break MaxAlloc
+func (l *Limits) checkAlloc(x int) error {
+	if l != nil && l.MaxAlloc > 0 && x > l.MaxAlloc {
+		return &ErrLimitViolation{Limit: `MaxAlloc`, Requested: int64(x), Max: int64(l.MaxAlloc)}
+	}
+	return nil
+}
+
+// Panics if a string or slice of bytes of length x would break MaxStringLen
+func (l *Limits) strLen(x int) {
+	if l != nil && l.MaxStringLen > 0 && x > l.MaxStringLen {
+		panic(&ErrLimitViolation{Limit: `MaxStringLen`, Requested: int64(x), Max: int64(l.MaxStringLen)})
+	}
+}
+
+// Panics if a string or slice of bytes of length x would break MaxStringLen or MaxAlloc
+func (l *Limits) str(x int) {
+	l.strLen(x)
+	l.alloc(x)
+}
+
+// Returns an error if reading x bytes from position pos would break MaxTotalBytes
+func (l *Limits) total(pos int64, x int) error {
+	if l != nil && l.MaxTotalBytes > 0 && pos + int64(x) > l.MaxTotalBytes {
+		return &ErrLimitViolation{Limit: `MaxTotalBytes`, Requested: pos + int64(x), Max: l.MaxTotalBytes}
+	}
+	return nil
+}
+
+// Returns how much can be read from position pos without breaking MaxTotalBytes, up to x
+func (l *Limits) room(pos int64, x int) int {
+	if l != nil && l.MaxTotalBytes > 0 && pos + int64(x) > l.MaxTotalBytes {
+		if pos >= l.MaxTotalBytes {
+			return 0
+		}
+		return int(l.MaxTotalBytes - pos)
+	}
+	return x
+}
+
+// Panics if x bytes cannot be read from the cursor, either because there are not that many left or because of MaxTotalBytes
+func (r *BytesReader) check(x int) {
+	if x < 0 || x > r.length - r.cursor {
+		panic(io.ErrUnexpectedEOF)
+	}
+	if err := r.limits.total(int64(r.cursor), x); err != nil {
+		panic(err)
+	}
+}
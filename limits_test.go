@@ -0,0 +1,144 @@
+package custom
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Returns what f panicked with as a *ErrLimitViolation, or nil
+func limitViolation(f func()) *ErrLimitViolation {
+	e, _ := catchPanic(f).(*ErrLimitViolation)
+	return e
+}
+
+func TestLimitsReadShortStream(t *testing.T) {
+	data := []byte(`hello, world`)
+	r := NewReader(bytes.NewReader(data))
+	r.SetLimits(Limits{MaxTotalBytes: 100})
+	b := make([]byte, 512)
+	n, err := r.Read(b)
+	if n != len(data) || err != io.EOF || !bytes.Equal(b[0:n], data) {
+		t.Fatalf(`Read returned %d, %v`, n, err)
+	}
+	if r.Buffered() != 0 {
+		t.Fatal(`Buffered is`, r.Buffered())
+	}
+	r = NewReader(bytes.NewReader(data))
+	r.SetLimits(Limits{MaxTotalBytes: 100})
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf(`ReadAll returned %q, %v`, got, err)
+	}
+}
+
+func TestLimitsReadAtLimit(t *testing.T) {
+	data := sectionTestData(300)
+	r := NewReader(bytes.NewReader(data))
+	r.SetLimits(Limits{MaxTotalBytes: 100})
+	got, err := io.ReadAll(r)
+	if !bytes.Equal(got, data[0:100]) {
+		t.Fatal(`ReadAll returned`, len(got), `bytes`)
+	}
+	if e, ok := err.(*ErrLimitViolation); !ok || e.Limit != `MaxTotalBytes` {
+		t.Fatal(`expected a MaxTotalBytes violation, got`, err)
+	}
+	if n, err := r.Read(make([]byte, 10)); n != 0 || err == nil || err == io.EOF {
+		t.Fatalf(`Read after the limit returned %d, %v`, n, err)
+	}
+	if limitViolation(func() { r.ReadByte() }) == nil {
+		t.Fatal(`ReadByte read beyond the limit`)
+	}
+	// a stream which ends exactly at the limit is not a violation
+	r = NewReader(bytes.NewReader(data[0:100]))
+	r.SetLimits(Limits{MaxTotalBytes: 100})
+	if got, err = io.ReadAll(r); err != nil || len(got) != 100 {
+		t.Fatal(`ReadAll returned`, len(got), err)
+	}
+	// reads larger than the buffer are limited too
+	r = NewReaderSize(bytes.NewReader(sectionTestData(5000)), 1024)
+	r.SetLimits(Limits{MaxTotalBytes: 3000})
+	b := make([]byte, 4000)
+	if n, err := r.Read(b); n != 3000 || err != nil {
+		t.Fatalf(`Read returned %d, %v`, n, err)
+	}
+}
+
+func TestLimitsReadx(t *testing.T) {
+	data := sectionTestData(300)
+	r := NewReader(bytes.NewReader(data))
+	r.SetLimits(Limits{MaxTotalBytes: 100, MaxAlloc: 1 << 20})
+	if got := r.Readx(60); !bytes.Equal(got, data[0:60]) {
+		t.Fatal(`Readx returned the wrong data`)
+	}
+	if e := limitViolation(func() { r.Readx(41) }); e == nil || e.Limit != `MaxTotalBytes` {
+		t.Fatal(`expected a MaxTotalBytes violation, got`, e)
+	}
+	if got := r.Readx(40); !bytes.Equal(got, data[60:100]) {
+		t.Fatal(`Readx after a violation returned the wrong data`)
+	}
+	if e := limitViolation(func() { r.Readx(1 << 30) }); e == nil || e.Limit != `MaxAlloc` {
+		t.Fatal(`expected a MaxAlloc violation, got`, e)
+	}
+	r = NewReader(bytes.NewReader(data[0:10]))
+	r.SetLimits(Limits{MaxTotalBytes: 100})
+	if e := catchPanic(func() { r.Readx(50) }); e != io.ErrUnexpectedEOF && e != io.EOF {
+		t.Fatal(`expected EOF, got`, e)
+	}
+}
+
+func TestLimitsReadString(t *testing.T) {
+	b := NewBuffer(0)
+	b.WriteString32(strings.Repeat(`a`, 1000))
+	b.WriteUint32(0xffffffff) // a corrupt length
+	data := append([]byte(nil), b.Bytes()...)
+
+	r := NewReader(bytes.NewReader(data))
+	r.SetLimits(Limits{MaxStringLen: 2000})
+	if len(r.ReadString32()) != 1000 {
+		t.Fatal(`ReadString32 returned the wrong length`)
+	}
+	if e := limitViolation(func() { r.ReadString32() }); e == nil || e.Limit != `MaxStringLen` {
+		t.Fatal(`expected a MaxStringLen violation, got`, e)
+	}
+	r = NewReader(bytes.NewReader(data))
+	r.SetLimits(Limits{MaxAlloc: 500})
+	if e := limitViolation(func() { r.ReadString32() }); e == nil || e.Limit != `MaxAlloc` {
+		t.Fatal(`expected a MaxAlloc violation, got`, e)
+	}
+	r = NewReader(bytes.NewReader(data))
+	r.SetLimits(Limits{MaxTotalBytes: 1004})
+	if len(r.ReadString32()) != 1000 {
+		t.Fatal(`ReadString32 returned the wrong length`)
+	}
+	if e := limitViolation(func() { r.ReadUint32() }); e == nil || e.Limit != `MaxTotalBytes` {
+		t.Fatal(`expected a MaxTotalBytes violation, got`, e)
+	}
+	r = NewReader(bytes.NewReader(data))
+	r.SetLimits(Limits{MaxTotalBytes: 500})
+	if e := limitViolation(func() { r.ReadString32() }); e == nil || e.Limit != `MaxTotalBytes` {
+		t.Fatal(`expected a MaxTotalBytes violation, got`, e)
+	}
+}
+
+func TestLimitsBytesReader(t *testing.T) {
+	b := NewBuffer(0)
+	b.WriteString32(strings.Repeat(`a`, 1000))
+	data := b.Bytes()
+	r := NewBytesReader(data)
+	r.SetLimits(Limits{MaxStringLen: 100})
+	if e := limitViolation(func() { r.ReadString32() }); e == nil || e.Limit != `MaxStringLen` {
+		t.Fatal(`expected a MaxStringLen violation, got`, e)
+	}
+	r = NewBytesReader(data)
+	r.SetLimits(Limits{MaxTotalBytes: 100})
+	if e := limitViolation(func() { r.ReadString32() }); e == nil || e.Limit != `MaxTotalBytes` {
+		t.Fatal(`expected a MaxTotalBytes violation, got`, e)
+	}
+	r = NewBytesReader(data)
+	r.SetLimits(Limits{})
+	if len(r.ReadString32()) != 1000 {
+		t.Fatal(`clearing the limits did not lift them`)
+	}
+}
@@ -0,0 +1,24 @@
+package custom
+
+import (
+ "errors"
+)
+
+// A hint about how a memory-mapped file will be read, given to OpenMmap
+type Advice uint8
+
+const (
+	AdviceNormal Advice = iota
+	AdviceSequential	// read from start to end, so pages can be read ahead aggressively and dropped after use
+	AdviceRandom	// read in no particular order, so reading ahead is wasted
+	AdviceWillNeed	// will be read soon, so start loading it now
+)
+
+var ErrFileTooLarge = errors.New(`File is too large to map into memory`)
+
+// Closes nothing, for files which have no mapping to release
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,72 @@
+//go:build linux
+
+package custom
+
+import (
+ "io"
+ "os"
+ "sync"
+ "syscall"
+)
+
+// Unmaps a memory-mapped file
+type mmapCloser struct {
+	data []byte
+	once sync.Once
+}
+
+func (m *mmapCloser) Close() (err error) {
+	m.once.Do(func() {
+		forgetAliases(m.data)
+		err = syscall.Munmap(m.data)
+		m.data = nil
+	})
+	return
+}
+
+// Maps a file into memory read-only and returns a BytesReader over it, so that ReadxRaw, ReadUTF8Raw and the Unsafe methods read straight from the page cache without copying.
+// The io.Closer unmaps the file, after which nothing read from the BytesReader without copying may be used, and CheckAliases stops checking the strings read from it; writing to the mapped memory faults.
+// Any advice is passed to madvise.
+func OpenMmap(filename string, advice ...Advice) (*BytesReader, io.Closer, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close() // the mapping outlives the file descriptor
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	size := fi.Size()
+	if size == 0 { // an empty file cannot be mapped
+		return NewBytesReader(nil), nopCloser{}, nil
+	}
+	if size != int64(int(size)) {
+		return nil, nil, ErrFileTooLarge
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, &os.PathError{Op: `mmap`, Path: filename, Err: err}
+	}
+	m := &mmapCloser{data: data}
+	for _, a := range advice {
+		var flag int
+		switch a {
+			case AdviceNormal:
+				flag = syscall.MADV_NORMAL
+			case AdviceSequential:
+				flag = syscall.MADV_SEQUENTIAL
+			case AdviceRandom:
+				flag = syscall.MADV_RANDOM
+			case AdviceWillNeed:
+				flag = syscall.MADV_WILLNEED
+			default:
+				continue
+		}
+		if err = syscall.Madvise(data, flag); err != nil {
+			m.Close()
+			return nil, nil, &os.PathError{Op: `madvise`, Path: filename, Err: err}
+		}
+	}
+	return NewBytesReader(data), m, nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package custom
+
+import (
+ "io"
+ "os"
+)
+
+// Reads a file into memory and returns a BytesReader over it. On Linux the file is memory-mapped instead, see mmap_linux.go; elsewhere the advice is ignored and the io.Closer does nothing.
+func OpenMmap(filename string, advice ...Advice) (*BytesReader, io.Closer, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewBytesReader(data), nopCloser{}, nil
+}
@@ -0,0 +1,83 @@
+package custom
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func mmapTestFile(t *testing.T, n int) string {
+	filename := filepath.Join(t.TempDir(), `mmap`)
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWriter(f)
+	for i := 0; i < n; i++ {
+		w.WriteString16(`record`)
+		w.WriteUint64Variable(uint64(i))
+	}
+	w.Close()
+	f.Close()
+	return filename
+}
+
+func TestMmap(t *testing.T) {
+	filename := mmapTestFile(t, 100000)
+	for _, advice := range [][]Advice{nil, {AdviceNormal}, {AdviceSequential, AdviceWillNeed}, {AdviceRandom}, {99}} {
+		r, c, err := OpenMmap(filename, advice...)
+		if err != nil {
+			t.Fatal(advice, err)
+		}
+		for i := 0; i < 100000; i++ {
+			if r.ReadString16Unsafe() != `record` || r.ReadUint64Variable() != uint64(i) {
+				t.Fatal(`record`, i, `read back wrong`)
+			}
+		}
+		if err = r.EOF(); err != nil {
+			t.Fatal(err)
+		}
+		if e := catchPanic(func() { r.Readx(1) }); e != io.ErrUnexpectedEOF {
+			t.Fatal(`expected io.ErrUnexpectedEOF past the end, got`, e)
+		}
+		if err = c.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err = c.Close(); err != nil {
+			t.Fatal(`closing twice:`, err)
+		}
+	}
+}
+
+// The strings read without copying are forgotten when the file is unmapped, so CheckAliases does not read unmapped memory
+func TestMmapCloseAliases(t *testing.T) {
+	r, c, err := OpenMmap(mmapTestFile(t, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5000; i++ {
+		r.ReadString16Unsafe()
+		r.ReadUint64Variable()
+	}
+	c.Close()
+	if err = CheckAliases(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMmapErrors(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, `empty`), nil, 0644)
+	r, c, err := OpenMmap(filepath.Join(dir, `empty`))
+	if err != nil || r.EOF() != nil {
+		t.Fatal(`an empty file:`, err)
+	}
+	c.Close()
+	if _, _, err = OpenMmap(filepath.Join(dir, `missing`)); !os.IsNotExist(err) {
+		t.Fatal(`expected a not exist error, got`, err)
+	}
+	if _, _, err = OpenMmap(dir); err == nil {
+		t.Fatal(`mapped a directory`)
+	}
+}
@@ -0,0 +1,444 @@
+package custom
+
+import (
+ "errors"
+ "io"
+ "runtime"
+ "sync"
+)
+
+// A block compressed stream begins with blockMagic, the Codec as 1 byte and the block size as a uint32.
+// Each block is then the compressed length as a uint32, the uncompressed length as a uint32, and the compressed data. If both lengths are equal then the block is stored uncompressed.
+// No block is longer than the block size, so that a reader knows how much it will have to allocate before it reads any blocks. The stream ends with a block in which both lengths are 0.
+const (
+	blockMagic = "CBLK"
+	blockStreamHeaderLen = len(blockMagic) + 5
+	blockMaxLen = 1 << 30
+)
+
+var ErrInvalidBlockSize = errors.New(`Invalid block size`)
+
+// -------- PARALLEL COMPRESSED WRITER --------
+
+type blockJob struct {
+	src, dst []byte
+	err error
+	ready chan struct{}
+	flushed chan struct{}	// for a marker queued by Flush, closed once every block before it has been written
+}
+
+// Cuts the stream into blocks which are compressed independently by a pool of workers, and written out in order
+type blockWriter struct {
+	w io.Writer
+	codec Codec
+	block *blockJob	// the block currently being filled
+	blockSize int
+	jobs, queue, free chan *blockJob
+	done chan struct{}
+	mu sync.Mutex
+	err error
+	index []int64	// for a seekable stream, pairs of the uncompressed and compressed offsets of each block
+}
+
+// Creates a new buffered writer wrapping an io.Writer, which compresses in independent blocks of blockSize bytes (uncompressed) on a pool of workers goroutines.
+// The output is in the same order as the input and is read with NewParallelReader. If blockSize <= 0 then 64 KiB is used, and if workers <= 0 then GOMAXPROCS is used.
+// The custom.Writer must be closed to finish the stream.
+func NewParallelCompressedWriter(f io.Writer, codec Codec, blockSize int, workers int) *Writer {
+	return &Writer{w: newBlockWriter(f, codec, blockSize, workers, false), data: pool.Get().([]byte), close: true, under: f}
+}
+
+func newBlockWriter(f io.Writer, codec Codec, blockSize int, workers int, seekable bool) *blockWriter {
+	if !codec.valid() {
+		panic(ErrUnknownCodec)
+	}
+	if blockSize <= 0 {
+		blockSize = bufferLen
+	}
+	if blockSize > blockMaxLen {
+		panic(ErrInvalidBlockSize)
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	bw := &blockWriter{
+		w: f,
+		codec: codec,
+		blockSize: blockSize,
+		jobs: make(chan *blockJob, workers),
+		queue: make(chan *blockJob, workers * 2),
+		free: make(chan *blockJob, workers * 2 + 2),
+		done: make(chan struct{}),
+	}
+	if seekable {
+		bw.index = make([]int64, 0, 64)
+	}
+	for i := 0; i < workers; i++ {
+		go bw.work()
+	}
+	go bw.output()
+	return bw
+}
+
+func (bw *blockWriter) work() {
+	for job := range bw.jobs {
+		job.dst, job.err = bw.codec.compress(job.dst[0:0], job.src)
+		job.ready <- struct{}{}
+	}
+}
+
+// Writes the compressed blocks to the underlying io.Writer in the order they were queued
+func (bw *blockWriter) output() {
+	defer close(bw.done)
+	var err error
+	header := make([]byte, 8)
+	putBlockHeader(header, bw.blockSize, 0)
+	_, err = bw.w.Write(append(append([]byte(blockMagic), byte(bw.codec)), header[0:4]...))
+	uoffset, coffset := int64(0), int64(blockStreamHeaderLen)
+	for job := range bw.queue {
+		<-job.ready
+		if job.flushed != nil {
+			close(job.flushed)
+			continue
+		}
+		if err == nil {
+			err = job.err
+		}
+		if err == nil {
+			payload := job.dst
+			if len(payload) >= len(job.src) {
+				payload = job.src // store uncompressed
+			}
+			if bw.index != nil {
+				bw.index = append(bw.index, uoffset, coffset)
+			}
+			putBlockHeader(header, len(payload), len(job.src))
+			if _, err = bw.w.Write(header); err == nil {
+				_, err = bw.w.Write(payload)
+			}
+			uoffset += int64(len(job.src))
+			coffset += int64(len(header) + len(payload))
+		}
+		if err != nil {
+			bw.setErr(err)
+		}
+		job.src = job.src[0:0]
+		select {
+			case bw.free <- job:
+			default:
+		}
+	}
+	if err == nil {
+		putBlockHeader(header, 0, 0)
+		_, err = bw.w.Write(header)
+	}
+	if err == nil && bw.index != nil {
+		err = writeBlockIndex(bw.w, bw.index, uoffset)
+	}
+	if err != nil {
+		bw.setErr(err)
+	}
+}
+
+func (bw *blockWriter) setErr(err error) {
+	bw.mu.Lock()
+	if bw.err == nil {
+		bw.err = err
+	}
+	bw.mu.Unlock()
+}
+
+func (bw *blockWriter) getErr() error {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	return bw.err
+}
+
+// Queues the current block for compression
+func (bw *blockWriter) dispatch() {
+	job := bw.block
+	bw.block = nil
+	bw.queue <- job
+	bw.jobs <- job
+}
+
+func (bw *blockWriter) Write(p []byte) (int, error) {
+	if err := bw.getErr(); err != nil {
+		return 0, err
+	}
+	var n int
+	for len(p) > 0 {
+		if bw.block == nil {
+			select {
+				case bw.block = <-bw.free:
+				default:
+					bw.block = &blockJob{src: make([]byte, 0, bw.blockSize), ready: make(chan struct{}, 1)}
+			}
+		}
+		l := bw.blockSize - len(bw.block.src)
+		if l > len(p) {
+			l = len(p)
+		}
+		bw.block.src = append(bw.block.src, p[0:l]...)
+		p = p[l:]
+		n += l
+		if len(bw.block.src) == bw.blockSize {
+			bw.dispatch()
+		}
+	}
+	return n, nil
+}
+
+// Compresses the current block, even though it is not full, and waits for all blocks to be written to the underlying io.Writer
+func (bw *blockWriter) Flush() error {
+	if bw.block != nil && len(bw.block.src) > 0 {
+		bw.dispatch()
+	}
+	marker := &blockJob{ready: make(chan struct{}, 1), flushed: make(chan struct{})}
+	marker.ready <- struct{}{}
+	bw.queue <- marker
+	<-marker.flushed
+	return bw.getErr()
+}
+
+// Compresses the final block, waits for all blocks to be written and then ends the stream. The underlying io.Writer is not closed.
+func (bw *blockWriter) Close() error {
+	if bw.block != nil && len(bw.block.src) > 0 {
+		bw.dispatch()
+	}
+	close(bw.jobs)
+	close(bw.queue)
+	<-bw.done
+	return bw.getErr()
+}
+
+func putBlockHeader(b []byte, clen, ulen int) {
+	b[0], b[1], b[2], b[3] = byte(clen), byte(clen >> 8), byte(clen >> 16), byte(clen >> 24)
+	b[4], b[5], b[6], b[7] = byte(ulen), byte(ulen >> 8), byte(ulen >> 16), byte(ulen >> 24)
+}
+
+// -------- PARALLEL DECOMPRESSION READER --------
+
+// Reads ahead the blocks of a block compressed stream, decompresses them on a pool of workers and serves them in order
+type blockReader struct {
+	r io.Reader
+	codec Codec
+	blockSize int	// from the stream header, the most that any block can need
+	workers int
+	limits *Limits	// the custom.Reader's, passed down by SetLimits
+	started bool	// the goroutines are only started by the first Read, so that SetLimits can be called first
+	jobs, queue, free chan *blockJob
+	stop chan struct{}
+	cur *blockJob	// the block currently being served
+	at int
+	err error
+	once sync.Once
+}
+
+// Creates a new buffered reader wrapping an io.Reader which contains a block compressed stream written by NewParallelCompressedWriter.
+// Up to workers blocks are read ahead and decompressed concurrently. If workers <= 0 then GOMAXPROCS is used. Panics if the stream header is invalid.
+func NewParallelReader(f io.Reader, workers int) *Reader {
+	r, err := OpenParallelReader(f, workers)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Creates a new buffered reader wrapping an io.Reader which contains a block compressed stream written by NewParallelCompressedWriter.
+// Up to workers blocks are read ahead and decompressed concurrently. If workers <= 0 then GOMAXPROCS is used. Returns an error if the stream header is invalid.
+// Nothing is read beyond the header until the first read, so that SetLimits can be called first. The block size in the header must then be within MaxAlloc, and blocks longer than it are rejected with ErrCorruptBlock.
+func OpenParallelReader(f io.Reader, workers int) (*Reader, error) {
+	br, err := newBlockReader(f, workers, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{f: br, buf: pool.Get().([]byte), close: true}, nil
+}
+
+func newBlockReader(f io.Reader, workers int, limits *Limits) (*blockReader, error) {
+	codec, blockSize, err := readBlockHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	br := &blockReader{
+		r: f,
+		codec: codec,
+		blockSize: blockSize,
+		workers: workers,
+		limits: limits,
+		jobs: make(chan *blockJob, workers),
+		queue: make(chan *blockJob, workers * 2),
+		free: make(chan *blockJob, workers * 2 + 2),
+		stop: make(chan struct{}),
+	}
+	return br, nil
+}
+
+// Checks the block size against MaxAlloc and then starts reading ahead
+func (br *blockReader) start() error {
+	br.started = true
+	if err := br.limits.checkAlloc(br.blockSize); err != nil {
+		return err
+	}
+	for i := 0; i < br.workers; i++ {
+		go br.work()
+	}
+	go br.input()
+	return nil
+}
+
+func (br *blockReader) setLimits(l *Limits) {
+	br.limits = l
+}
+
+// Reads the stream header, returning the codec and the block size
+func readBlockHeader(f io.Reader) (Codec, int, error) {
+	var header [blockStreamHeaderLen]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, 0, err
+	}
+	if string(header[0:len(blockMagic)]) != blockMagic {
+		return 0, 0, ErrInvalidHeader
+	}
+	codec := Codec(header[len(blockMagic)])
+	if !codec.valid() {
+		return 0, 0, ErrUnknownCodec
+	}
+	b := header[len(blockMagic)+1:]
+	blockSize := int(uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24)
+	if blockSize <= 0 || blockSize > blockMaxLen {
+		return 0, 0, ErrInvalidBlockSize
+	}
+	return codec, blockSize, nil
+}
+
+func (br *blockReader) work() {
+	for job := range br.jobs {
+		if job.err == nil {
+			if len(job.src) == len(job.dst) {
+				copy(job.dst, job.src) // stored uncompressed
+			} else {
+				job.err = br.codec.decompress(job.dst, job.src)
+			}
+		}
+		job.ready <- struct{}{}
+	}
+}
+
+// Reads the compressed blocks from the underlying io.Reader and queues them for decompression
+func (br *blockReader) input() {
+	defer close(br.jobs)
+	defer close(br.queue)
+	header := make([]byte, 8)
+	for {
+		var job *blockJob
+		select {
+			case job = <-br.free:
+			default:
+				job = &blockJob{ready: make(chan struct{}, 1)}
+		}
+		clen, ulen, err := readBlock(br.r, header, job, br.blockSize)
+		if err == nil && clen == 0 && ulen == 0 {
+			return // end of stream
+		}
+		job.err = err
+		select {
+			case br.queue <- job:
+			case <-br.stop:
+				return
+		}
+		select {
+			case br.jobs <- job:
+			case <-br.stop:
+				return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Reads one block into job.src, sizing job.dst for its uncompressed data, which must be no longer than blockSize
+func readBlock(r io.Reader, header []byte, job *blockJob, blockSize int) (clen, ulen int, err error) {
+	if _, err = io.ReadFull(r, header); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	clen = int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16 | uint32(header[3])<<24)
+	ulen = int(uint32(header[4]) | uint32(header[5])<<8 | uint32(header[6])<<16 | uint32(header[7])<<24)
+	if ulen > blockSize || clen > ulen || (clen == 0) != (ulen == 0) {
+		err = ErrCorruptBlock
+		return
+	}
+	if cap(job.src) < clen {
+		job.src = make([]byte, clen)
+	}
+	if cap(job.dst) < ulen {
+		job.dst = make([]byte, ulen)
+	}
+	job.src, job.dst = job.src[0:clen], job.dst[0:ulen]
+	if _, err = io.ReadFull(r, job.src); err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+func (br *blockReader) Read(p []byte) (int, error) {
+	if !br.started {
+		if br.err = br.start(); br.err != nil {
+			return 0, br.err
+		}
+	}
+	for br.cur == nil || br.at == len(br.cur.dst) {
+		if br.err != nil {
+			return 0, br.err
+		}
+		if br.cur != nil {
+			select {
+				case br.free <- br.cur:
+				default:
+			}
+			br.cur = nil
+		}
+		job, ok := <-br.queue
+		if !ok {
+			br.err = io.EOF
+			return 0, io.EOF
+		}
+		<-job.ready
+		if job.err != nil {
+			br.err = job.err
+			return 0, job.err
+		}
+		br.cur, br.at = job, 0
+	}
+	n := copy(p, br.cur.dst[br.at:])
+	br.at += n
+	return n, nil
+}
+
+// Stops reading ahead. The underlying io.Reader is not closed.
+func (br *blockReader) Close() error {
+	br.once.Do(func() {
+		close(br.stop)
+	})
+	return nil
+}
+
+// Stops the goroutines and starts new ones reading the stream in f
+func (br *blockReader) reset(f io.Reader) (io.Reader, error) {
+	br.Close()
+	nbr, err := newBlockReader(f, br.workers, br.limits)
+	if err != nil {
+		return nil, err
+	}
+	return nbr, nil
+}
@@ -0,0 +1,208 @@
+package custom
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// Writes n uint64s through a parallel compressed writer and returns the stream
+func parallelTestStream(t *testing.T, codec Codec, blockSize, n int) []byte {
+	var b bytes.Buffer
+	w := NewParallelCompressedWriter(&b, codec, blockSize, 4)
+	for i := 0; i < n; i++ {
+		w.WriteUint64Variable(uint64(i % 300))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestParallelRoundTrip(t *testing.T) {
+	for _, codec := range []Codec{CodecNone, CodecZlib, CodecSnappy, CodecZstd} {
+		for _, n := range []int{0, 1, 100000} {
+			data := parallelTestStream(t, codec, 1000, n)
+			if string(data[0:len(blockMagic)]) != blockMagic || Codec(data[len(blockMagic)]) != codec {
+				t.Fatal(`invalid stream header`)
+			}
+			for _, workers := range []int{0, 1, 3} {
+				r, err := OpenParallelReader(bytes.NewReader(data), workers)
+				if err != nil {
+					t.Fatal(codec, err)
+				}
+				for i := 0; i < n; i++ {
+					if v := r.ReadUint64Variable(); v != uint64(i % 300) {
+						t.Fatalf(`codec %d: value %d read as %d`, codec, i, v)
+					}
+				}
+				if err = r.EOF(); err != nil {
+					t.Fatal(codec, err)
+				}
+				r.Close()
+			}
+		}
+	}
+}
+
+// Sync writes out a short block, which can be read before the stream is closed
+func TestParallelSync(t *testing.T) {
+	var b bytes.Buffer
+	w := NewParallelCompressedWriter(&b, CodecZlib, 1000, 2)
+	w.WriteString(`first`)
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	r := NewParallelReader(bytes.NewReader(append([]byte(nil), b.Bytes()...)), 1)
+	if got := string(r.Readx(5)); got != `first` {
+		t.Fatal(`read back`, got)
+	}
+	if e := catchPanic(func() { r.ReadByte() }); e != io.ErrUnexpectedEOF {
+		t.Fatal(`expected io.ErrUnexpectedEOF from the unfinished stream, got`, e)
+	}
+	w.Close()
+}
+
+func TestParallelHeader(t *testing.T) {
+	data := parallelTestStream(t, CodecSnappy, 1000, 100)
+	for _, c := range []struct {
+		name string
+		data []byte
+		err error
+	}{
+		{`empty`, nil, io.ErrUnexpectedEOF},
+		{`short`, data[0:len(blockMagic) + 2], io.ErrUnexpectedEOF},
+		{`magic`, append([]byte(`CBLX`), data[len(blockMagic):]...), ErrInvalidHeader},
+		{`codec`, append([]byte("CBLK\x09"), data[len(blockMagic) + 1:]...), ErrUnknownCodec},
+		{`zero block size`, append([]byte("CBLK\x02\x00\x00\x00\x00"), data[blockStreamHeaderLen:]...), ErrInvalidBlockSize},
+		{`huge block size`, append([]byte("CBLK\x02\x00\x00\x00\x80"), data[blockStreamHeaderLen:]...), ErrInvalidBlockSize},
+	} {
+		if _, err := OpenParallelReader(bytes.NewReader(c.data), 1); err != c.err {
+			t.Fatalf(`%s: expected %v, got %v`, c.name, c.err, err)
+		}
+	}
+}
+
+// Reads everything from a parallel reader over data, returning the error
+func readParallel(data []byte, limits Limits) error {
+	r, err := OpenParallelReader(bytes.NewReader(data), 2)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	r.SetLimits(limits)
+	_, err = io.ReadAll(r)
+	return err
+}
+
+// A hostile block header cannot force an allocation larger than the block size, nor one larger than MaxAlloc
+func TestParallelBlockLimits(t *testing.T) {
+	data := parallelTestStream(t, CodecSnappy, 1000, 10000)
+	if err := readParallel(data, Limits{MaxAlloc: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := readParallel(data, Limits{MaxAlloc: 999}).(*ErrLimitViolation); !ok || e.Limit != `MaxAlloc` {
+		t.Fatal(`expected a MaxAlloc violation, got`, e)
+	}
+	hostile := append([]byte("CBLK\x02\xe8\x03\x00\x00"), 20, 0, 0, 0, 0, 0, 0, 0x40) // a block claiming 1 GiB
+	hostile = append(hostile, make([]byte, 20)...)
+	if err := readParallel(hostile, Limits{}); err != ErrCorruptBlock {
+		t.Fatal(`expected ErrCorruptBlock, got`, err)
+	}
+	big := append([]byte("CBLK\x02\x00\x00\x00\x40"), data[blockStreamHeaderLen:]...) // a header claiming 1 GiB blocks
+	if e, ok := readParallel(big, Limits{MaxAlloc: 1 << 20}).(*ErrLimitViolation); !ok || e.Limit != `MaxAlloc` {
+		t.Fatal(`expected a MaxAlloc violation, got`, e)
+	}
+}
+
+func TestParallelCorrupt(t *testing.T) {
+	data := parallelTestStream(t, CodecZlib, 1000, 10000)
+	for _, n := range []int{blockStreamHeaderLen, blockStreamHeaderLen + 4, len(data) / 2, len(data) - 8, len(data) - 1} {
+		if err := readParallel(data[0:n], Limits{}); err != io.ErrUnexpectedEOF {
+			t.Fatalf(`truncated to %d bytes: expected io.ErrUnexpectedEOF, got %v`, n, err)
+		}
+	}
+	bad := append([]byte(nil), data...)
+	bad[blockStreamHeaderLen + 8 + 10] ^= 0xff // inside the first compressed block
+	if err := readParallel(bad, Limits{}); err == nil {
+		t.Fatal(`a corrupt block was not detected`)
+	}
+	bad = append([]byte(nil), data...)
+	bad[blockStreamHeaderLen] = bad[blockStreamHeaderLen + 4] + 1 // compressed longer than uncompressed
+	bad[blockStreamHeaderLen + 1] = bad[blockStreamHeaderLen + 5]
+	if err := readParallel(bad, Limits{}); err != ErrCorruptBlock {
+		t.Fatal(`expected ErrCorruptBlock, got`, err)
+	}
+}
+
+// Walks the blocks of a stream, checking each is no longer than the block size and that only the last is short
+func TestParallelWriterBlocks(t *testing.T) {
+	rnd := sectionTestData(10000)
+	for i := range rnd {
+		rnd[i] = byte(i * 7919 >> 3 ^ i * 31)
+	}
+	for _, c := range []struct {
+		name string
+		codec Codec
+		payload []byte
+	}{
+		{`text`, CodecZlib, bytes.Repeat([]byte(`compressible `), 1000)},
+		{`noise`, CodecSnappy, rnd},
+		{`none`, CodecNone, rnd},
+	} {
+		var b bytes.Buffer
+		w := NewParallelCompressedWriter(&b, c.codec, 1000, 3)
+		w.Write(c.payload)
+		if err := w.Close(); err != nil {
+			t.Fatal(c.name, err)
+		}
+		r := NewBytesReader(b.Bytes())
+		r.Readx(len(blockMagic) + 1)
+		if r.ReadUint32() != 1000 {
+			t.Fatal(c.name, `the header has the wrong block size`)
+		}
+		var total int
+		for {
+			clen, ulen := int(r.ReadUint32()), int(r.ReadUint32())
+			if clen == 0 && ulen == 0 {
+				break
+			}
+			if ulen > 1000 || clen > ulen || (ulen < 1000 && total + ulen != len(c.payload)) {
+				t.Fatalf(`%s: block at %d has lengths %d, %d`, c.name, total, clen, ulen)
+			}
+			block := r.Readx(clen)
+			if clen == ulen && !bytes.Equal(block, c.payload[total:total + ulen]) {
+				t.Fatal(c.name, `a stored block does not hold the data`)
+			}
+			if c.codec == CodecNone && clen != ulen {
+				t.Fatal(c.name, `a block was compressed`)
+			}
+			total += ulen
+		}
+		if total != len(c.payload) || r.EOF() != nil {
+			t.Fatal(c.name, `the blocks hold`, total, `bytes`)
+		}
+	}
+}
+
+func TestParallelWriterErrors(t *testing.T) {
+	if e := catchPanic(func() { NewParallelCompressedWriter(io.Discard, 99, 1000, 1) }); e != ErrUnknownCodec {
+		t.Fatal(`expected ErrUnknownCodec, got`, e)
+	}
+	if e := catchPanic(func() { NewParallelCompressedWriter(io.Discard, CodecZlib, blockMaxLen + 1, 1) }); e != ErrInvalidBlockSize {
+		t.Fatal(`expected ErrInvalidBlockSize, got`, e)
+	}
+	w := NewParallelCompressedWriter(&failingWriter{n: 5000}, CodecNone, 1000, 2)
+	w.Write(sectionTestData(100000))
+	if err := w.Close(); err != errTestWrite {
+		t.Fatal(`expected the write error from Close, got`, err)
+	}
+	// the default block size
+	var b bytes.Buffer
+	w = NewParallelCompressedWriter(&b, CodecNone, 0, 0)
+	w.Write(sectionTestData(bufferLen + 1))
+	w.Close()
+	if d := b.Bytes(); int(d[len(blockMagic) + 1]) | int(d[len(blockMagic) + 2]) << 8 | int(d[len(blockMagic) + 3]) << 16 != bufferLen {
+		t.Fatal(`the default block size is not`, bufferLen)
+	}
+}
@@ -0,0 +1,90 @@
+package custom
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBufferPatch(t *testing.T) {
+	b := NewBuffer(0)
+	length := b.Reserve(4)
+	count := b.Reserve(9)
+	b.WriteString(`hello`)
+	if err := b.PatchUint32(length, uint32(b.Since(length))); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.PatchUint64Variable(count, 300); err != nil {
+		t.Fatal(err)
+	}
+	r := b.Reader()
+	if v := r.ReadUint32(); v != 14 {
+		t.Fatal(`length patched as`, v)
+	}
+	if v := r.ReadUint64Variable(); v != 300 {
+		t.Fatal(`count patched as`, v)
+	}
+	if s := string(r.ReadxRaw(5)); s != `hello` {
+		t.Fatal(`data after the marks is`, s)
+	}
+	if err := r.EOF(); err != nil {
+		t.Fatal(err)
+	}
+
+	b = NewBuffer(0)
+	m16, m24, m48, m64 := b.Reserve(2), b.Reserve(3), b.Reserve(6), b.Reserve(8)
+	b.PatchUint16(m16, 0xBEEF)
+	b.PatchUint24(m24, 0xABCDEF)
+	b.PatchUint48(m48, 0x123456789ABC)
+	b.PatchUint64(m64, 0x0102030405060708)
+	r = b.Reader()
+	if r.ReadUint16() != 0xBEEF || r.ReadUint24() != 0xABCDEF || r.ReadUint48() != 0x123456789ABC || r.ReadUint64() != 0x0102030405060708 {
+		t.Fatal(`patched values read back wrongly`)
+	}
+}
+
+func TestBufferPatchErrors(t *testing.T) {
+	b := NewBuffer(0)
+	m := b.Reserve(4)
+	if err := b.PatchUint16(m, 1); err != ErrMarkLength {
+		t.Fatal(`expected ErrMarkLength, got`, err)
+	}
+	small := b.Reserve(2)
+	if err := b.PatchUint64Variable(small, 1 << 40); err != ErrMarkLength {
+		t.Fatal(`expected ErrMarkLength for a value too large, got`, err)
+	}
+	b.Reset()
+	if err := b.PatchUint32(m, 1); err != ErrMarkInvalid {
+		t.Fatal(`expected ErrMarkInvalid after Reset, got`, err)
+	}
+	before := b.Len()
+	if e := catchPanic(func() { b.Reserve(-1) }); e == nil {
+		t.Fatal(`Reserve accepted a negative count`)
+	}
+	if b.Len() != before {
+		t.Fatal(`a negative Reserve moved the cursor`)
+	}
+	if m := b.Reserve(0); b.Since(m) != 0 {
+		t.Fatal(`Reserve(0) reserved something`)
+	}
+}
+
+func TestBufferWriteAt(t *testing.T) {
+	b := NewBuffer(0)
+	b.WriteString(`abc`)
+	b.WriteAt([]byte(`XYZ`), 2)
+	if b.String() != `abXYZ` {
+		t.Fatal(`WriteAt over the end gave`, b.String())
+	}
+	b.WriteAt([]byte(`!`), 8)
+	if !bytes.Equal(b.Bytes(), []byte("abXYZ\x00\x00\x00!")) {
+		t.Fatalf(`WriteAt beyond the end gave %q`, b.Bytes())
+	}
+	if _, err := b.WriteAt([]byte(`x`), -1); err == nil {
+		t.Fatal(`WriteAt accepted a negative offset`)
+	}
+	big := NewBuffer(0)
+	big.WriteAt([]byte(`x`), 200000)
+	if big.Len() != 200001 {
+		t.Fatal(`WriteAt did not grow the buffer, length`, big.Len())
+	}
+}
@@ -0,0 +1,128 @@
+package custom
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReaderPeek(t *testing.T) {
+	r := NewReader(io.MultiReader(strings.NewReader(`héllo wörld`)))
+	if got := r.Peek(3); string(got) != `hé` || r.Offset() != 0 {
+		t.Fatalf(`Peek returned %q`, got)
+	}
+	if r.UnreadByte() != ErrUnread || r.UnreadRune() != ErrUnread {
+		t.Fatal(`unread before anything was read`)
+	}
+	if r.PeekByte() != 'h' || r.ReadByte() != 'h' {
+		t.Fatal(`PeekByte did not return the next byte`)
+	}
+	if r.ReadRune() != 'é' {
+		t.Fatal(`ReadRune after PeekByte`)
+	}
+	if err := r.UnreadRune(); err != nil {
+		t.Fatal(err)
+	}
+	if r.ReadRune() != 'é' || r.Offset() != 3 {
+		t.Fatal(`ReadRune after UnreadRune`)
+	}
+	if err := r.UnreadByte(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Buffered() != len(`héllo wörld`) - 2 {
+		t.Fatal(`Buffered is`, r.Buffered())
+	}
+	if got := r.Peek(100); string(got) != "\xa9llo wörld" {
+		t.Fatalf(`Peek past the end returned %q`, got)
+	}
+	r.Discard(r.Buffered())
+	if got := r.Peek(1); len(got) != 0 {
+		t.Fatalf(`Peek at the end returned %q`, got)
+	}
+	if e := catchPanic(func() { r.PeekByte() }); e != io.EOF {
+		t.Fatal(`expected io.EOF from PeekByte at the end, got`, e)
+	}
+}
+
+// Once a Peek has refilled the buffer the bytes before it can no longer be unread
+func TestReaderPeekRefill(t *testing.T) {
+	data := sectionTestData(3 * bufferLen)
+	r := NewReader(bytes.NewReader(data))
+	r.Readx(bufferLen - 10)
+	if got := r.Peek(100); !bytes.Equal(got, data[bufferLen - 10:bufferLen + 90]) {
+		t.Fatal(`Peek across a refill returned the wrong data`)
+	}
+	if r.UnreadByte() != ErrUnread {
+		t.Fatal(`unread a byte which is no longer in the buffer`)
+	}
+	if got := r.Readx(100); !bytes.Equal(got, data[bufferLen - 10:bufferLen + 90]) {
+		t.Fatal(`Readx after Peek returned the wrong data`)
+	}
+	if err := r.UnreadByte(); err != nil {
+		t.Fatal(err)
+	}
+	if r.ReadByte() != data[bufferLen + 89] {
+		t.Fatal(`read the wrong byte after UnreadByte`)
+	}
+	if e := catchPanic(func() { r.Peek(bufferLen + 1) }); e != ErrPeekTooLarge {
+		t.Fatal(`expected ErrPeekTooLarge, got`, e)
+	}
+	// a Peek within the buffer does not refill it
+	r.ReadByte()
+	r.Peek(1)
+	if r.UnreadByte() != nil {
+		t.Fatal(`could not unread after a Peek within the buffer`)
+	}
+}
+
+func TestReaderUnreadRuneInvalid(t *testing.T) {
+	r := NewReader(strings.NewReader("a\xffb"))
+	r.Readx(2)
+	if r.UnreadRune() != ErrUnread {
+		t.Fatal(`unread an invalid rune`)
+	}
+}
+
+func TestBytesReaderPeek(t *testing.T) {
+	r := NewBytesReader([]byte(`añb`))
+	if r.UnreadByte() != ErrUnread || r.UnreadRune() != ErrUnread {
+		t.Fatal(`unread at the beginning`)
+	}
+	r.ReadByte()
+	if r.ReadRune() != 'ñ' {
+		t.Fatal(`ReadRune`)
+	}
+	if err := r.UnreadRune(); err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Peek(10)) != `ñb` || r.Buffered() != 3 || r.PeekByte() != 0xc3 || r.Offset() != 1 {
+		t.Fatal(`Peek after UnreadRune`)
+	}
+	if got := r.Peek(0); len(got) != 0 {
+		t.Fatalf(`Peek(0) returned %q`, got)
+	}
+	r.Discard(3)
+	if r.Peek(1) != nil || r.Buffered() != 0 {
+		t.Fatal(`Peek at the end`)
+	}
+	if err := r.UnreadByte(); err != nil || r.ReadByte() != 'b' {
+		t.Fatal(`UnreadByte at the end`)
+	}
+	r = NewBytesReader([]byte("a\xff"))
+	r.Readx(2)
+	if r.UnreadRune() != ErrUnread {
+		t.Fatal(`unread an invalid rune`)
+	}
+}
+
+func TestPeekNegative(t *testing.T) {
+	for _, f := range []func(){
+		func() { NewBytesReader([]byte(`abc`)).Peek(-1) },
+		func() { NewReader(strings.NewReader(`abc`)).Peek(-1) },
+	} {
+		if err, ok := catchPanic(f).(error); !ok || !strings.Contains(err.Error(), `negative count`) {
+			t.Fatal(`expected a negative count panic, got`, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package custom
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestBufferClass(t *testing.T) {
+	for _, c := range []struct {
+		n, class, size int
+	}{
+		{-1, 0, 512},
+		{0, 0, 512},
+		{1, 0, 512},
+		{512, 0, 512},
+		{513, 1, 1024},
+		{65536, 7, 65536},
+		{65537, 8, 131072},
+		{maxBufferLen, maxBufferShift - minBufferShift, maxBufferLen},
+	} {
+		if class := bufferClass(c.n); class != c.class {
+			t.Fatalf(`bufferClass(%d) is %d, not %d`, c.n, class, c.class)
+		}
+		b := getBuffer(c.n)
+		if len(b) != c.size || cap(b) != c.size {
+			t.Fatalf(`getBuffer(%d) returned %d bytes, not %d`, c.n, len(b), c.size)
+		}
+		putBuffer(b)
+	}
+	if b := getBuffer(maxBufferLen + 1); len(b) != maxBufferLen + 1 {
+		t.Fatal(`a buffer larger than the pools is`, len(b), `bytes`)
+	}
+	if len(pool.Get().([]byte)) != bufferLen {
+		t.Fatal(`the default pool does not hold buffers of bufferLen`)
+	}
+	// buffers which are not a pooled size are not pooled
+	putBuffer(make([]byte, 1000))
+	putBuffer(make([]byte, 100))
+	putBuffer(make([]byte, 512)[0:10])
+	for i := 0; i < 10; i++ {
+		if b := getBuffer(1000); len(b) != 1024 {
+			t.Fatal(`getBuffer(1000) returned`, len(b), `bytes`)
+		}
+		if b := getBuffer(512); len(b) != 512 {
+			t.Fatal(`getBuffer(512) returned`, len(b), `bytes`)
+		}
+	}
+}
+
+func TestSizedWriterReader(t *testing.T) {
+	for _, size := range []int{-1, 0, 1, 600, 4096, 1 << 20} {
+		var out bytes.Buffer
+		w := NewWriterSize(&out, size)
+		if want := getBuffer(size); len(w.data) != len(want) {
+			t.Fatal(size, `the writer's buffer is`, len(w.data), `bytes`)
+		}
+		for i := 0; i < 20000; i++ {
+			w.WriteUint64(uint64(i))
+			w.Write9Bytes(1, 2, 3, 4, 5, 6, 7, 8, 9)
+			w.WriteString16(`hello`)
+			w.WriteRune('€')
+		}
+		w.Write(sectionTestData(5000))
+		if err := w.Close(); err != nil {
+			t.Fatal(size, err)
+		}
+		r := NewReaderSize(io.MultiReader(bytes.NewReader(out.Bytes())), size)
+		for i := 0; i < 20000; i++ {
+			if r.ReadUint64() != uint64(i) || string(r.ReadxRaw(9)) != "\x01\x02\x03\x04\x05\x06\x07\x08\x09" || r.ReadString16() != `hello` || r.ReadRune() != '€' {
+				t.Fatal(size, `record`, i, `read back wrong`)
+			}
+		}
+		if !bytes.Equal(r.Readx(5000), sectionTestData(5000)) || r.EOF() != nil {
+			t.Fatal(size, `read back the wrong data at the end`)
+		}
+		r.Close()
+	}
+}
+
+// A small buffer cannot Peek more than it holds, but can Readx any amount
+func TestSizedReaderSmall(t *testing.T) {
+	data := sectionTestData(3000)
+	r := NewReaderSize(bytes.NewReader(data), 1)
+	if len(r.buf) != minBufferLen {
+		t.Fatal(`the reader's buffer is`, len(r.buf), `bytes`)
+	}
+	if e := catchPanic(func() { r.Peek(minBufferLen + 1) }); e != ErrPeekTooLarge {
+		t.Fatal(`expected ErrPeekTooLarge, got`, e)
+	}
+	if !bytes.Equal(r.Peek(minBufferLen), data[0:minBufferLen]) {
+		t.Fatal(`Peek returned the wrong data`)
+	}
+	if !bytes.Equal(r.Readx(2000), data[0:2000]) {
+		t.Fatal(`Readx larger than the buffer returned the wrong data`)
+	}
+	if e := catchPanic(func() { r.Readx(1001) }); e != io.ErrUnexpectedEOF {
+		t.Fatal(`expected io.ErrUnexpectedEOF, got`, e)
+	}
+}
+
+func TestNewBufferSize(t *testing.T) {
+	for _, size := range []int{-1, 0, 1, 600, 4096, 1 << 20} {
+		b := NewBufferSize(size)
+		if want := len(getBuffer(size)); b.Cap() != want {
+			t.Fatal(size, `the buffer holds`, b.Cap(), `bytes, not`, want)
+		}
+		b.WriteString(`abc`)
+		b.Write(sectionTestData(5000))
+		if !bytes.Equal(b.Bytes(), append([]byte(`abc`), sectionTestData(5000)...)) {
+			t.Fatal(size, `read back the wrong data`)
+		}
+		b.Close()
+	}
+}
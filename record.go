@@ -0,0 +1,131 @@
+package custom
+
+import (
+ "errors"
+ "io"
+)
+
+// Each record is written as its length as a uint32 followed by its contents.
+
+// The maximum length of a record accepted by a RecordReader unless another is given
+const DefaultMaxRecordLen = 64 << 20
+
+var ErrRecordTooLarge = errors.New(`Record too large`)
+var ErrRecordNotOpen = errors.New(`No record has been begun`)
+
+// -------- RECORD WRITER --------
+
+// Writes length-prefixed records, such as the messages of a log or a socket protocol
+type RecordWriter struct {
+	w *Writer
+	buf *Buffer
+	mark Mark
+	own, open bool
+}
+
+// Creates a new RecordWriter wrapping an io.Writer
+func NewRecordWriter(f io.Writer) *RecordWriter {
+	_, isWriter := f.(*Writer)
+	return &RecordWriter{w: NewWriter(f), buf: NewBuffer(0), own: !isWriter}
+}
+
+// Begins a new record and returns the Interface to write its contents to. The length is filled in by EndRecord.
+// The returned Interface is only valid until EndRecord and must not be closed.
+func (rw *RecordWriter) BeginRecord() Interface {
+	rw.buf.Reset()
+	rw.mark = rw.buf.Reserve(4)
+	rw.open = true
+	return rw.buf
+}
+
+// Ends the record begun with BeginRecord, filling in its length and writing it out
+func (rw *RecordWriter) EndRecord() error {
+	if !rw.open {
+		return ErrRecordNotOpen
+	}
+	rw.open = false
+	l := rw.buf.Since(rw.mark)
+	if uint64(l) > 4294967295 {
+		return ErrRecordTooLarge
+	}
+	rw.buf.PatchUint32(rw.mark, uint32(l))
+	_, err := rw.w.Write(rw.buf.Bytes())
+	return err
+}
+
+// Writes one record, the contents of which are written by fn
+func (rw *RecordWriter) WriteRecord(fn func(Interface)) error {
+	fn(rw.BeginRecord())
+	return rw.EndRecord()
+}
+
+// Flushes the buffered records to the underlying io.Writer
+func (rw *RecordWriter) Flush() error {
+	return rw.w.Flush()
+}
+
+// Flushes the buffered records and releases the buffers back to the pool. The underlying io.Writer is not closed.
+func (rw *RecordWriter) Close() error {
+	rw.buf.Close()
+	if rw.own {
+		return rw.w.Close()
+	}
+	return rw.w.Flush()
+}
+
+// -------- RECORD READER --------
+
+// Reads records written by RecordWriter
+type RecordReader struct {
+	r *Reader
+	own bool
+	max int
+	rec []byte
+	br BytesReader
+}
+
+// Creates a new RecordReader wrapping an io.Reader. Any record longer than max bytes is rejected with ErrRecordTooLarge before it is allocated, as its length must be corrupt.
+// If max <= 0 then DefaultMaxRecordLen is used.
+func NewRecordReader(f io.Reader, max int) *RecordReader {
+	if max <= 0 {
+		max = DefaultMaxRecordLen
+	}
+	r, isReader := f.(*Reader)
+	if !isReader {
+		r = NewReader(f)
+	}
+	return &RecordReader{r: r, own: !isReader, max: max}
+}
+
+// Reads the next record and returns a BytesReader over its contents, which is only valid until the next call to Next.
+// Returns io.EOF when there are no more records, or io.ErrUnexpectedEOF if the stream ends part way through a record.
+func (rr *RecordReader) Next() (*BytesReader, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(rr.r, header[:]); err != nil {
+		return nil, err
+	}
+	l := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16 | uint32(header[3])<<24)
+	if l > rr.max || l < 0 {
+		return nil, ErrRecordTooLarge
+	}
+	if cap(rr.rec) < l {
+		rr.rec = make([]byte, l)
+	}
+	rec := rr.rec[0:l:l]
+	if _, err := io.ReadFull(rr.r, rec); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	rr.br = BytesReader{data: rec, length: l}
+	return &rr.br, nil
+}
+
+// Releases the buffer back to the pool. The underlying io.Reader is not closed.
+func (rr *RecordReader) Close() error {
+	if rr.own {
+		return rr.r.Close()
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package custom
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// Writes 1000 small records, an empty one and a large one
+func recordTestStream(t *testing.T) []byte {
+	var b bytes.Buffer
+	rw := NewRecordWriter(&b)
+	for i := 0; i < 1000; i++ {
+		if err := rw.WriteRecord(func(w Interface) { w.WriteUint32(uint32(i)); w.WriteString16(`x`) }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rw.BeginRecord()
+	rw.EndRecord()
+	rw.BeginRecord().Write(sectionTestData(200000))
+	if err := rw.EndRecord(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	data := recordTestStream(t)
+	rr := NewRecordReader(bytes.NewReader(data), 0)
+	for i := 0; i < 1000; i++ {
+		r, err := rr.Next()
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if r.ReadUint32() != uint32(i) || r.ReadString16() != `x` || r.EOF() != nil {
+			t.Fatal(`record`, i, `read back wrong`)
+		}
+		if e := catchPanic(func() { r.ReadByte() }); e == nil {
+			t.Fatal(`read past the end of record`, i)
+		}
+	}
+	r, err := rr.Next()
+	if err != nil || r.EOF() != nil {
+		t.Fatal(`the empty record:`, err)
+	}
+	r, err = rr.Next()
+	if err != nil || !bytes.Equal(r.ReadxRaw(200000), sectionTestData(200000)) {
+		t.Fatal(`the large record:`, err)
+	}
+	if _, err = rr.Next(); err != io.EOF {
+		t.Fatal(`expected io.EOF, got`, err)
+	}
+	rr.Close()
+}
+
+func TestRecordErrors(t *testing.T) {
+	data := recordTestStream(t)
+	rr := NewRecordReader(bytes.NewReader(data), 100)
+	for i := 0; i < 1001; i++ {
+		if _, err := rr.Next(); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	if _, err := rr.Next(); err != ErrRecordTooLarge {
+		t.Fatal(`expected ErrRecordTooLarge, got`, err)
+	}
+	for _, n := range []int{1, 3, 5, 10} {
+		if _, err := NewRecordReader(bytes.NewReader(data[0:n]), 0).Next(); err != io.ErrUnexpectedEOF {
+			t.Fatalf(`truncated to %d bytes: expected io.ErrUnexpectedEOF, got %v`, n, err)
+		}
+	}
+	hostile := []byte{0xff, 0xff, 0xff, 0xff}
+	if _, err := NewRecordReader(bytes.NewReader(hostile), 0).Next(); err != ErrRecordTooLarge {
+		t.Fatal(`expected ErrRecordTooLarge, got`, err)
+	}
+	if err := NewRecordWriter(io.Discard).EndRecord(); err != ErrRecordNotOpen {
+		t.Fatal(`expected ErrRecordNotOpen, got`, err)
+	}
+	rw := NewRecordWriter(io.Discard)
+	rw.WriteRecord(func(w Interface) {})
+	if err := rw.EndRecord(); err != ErrRecordNotOpen {
+		t.Fatal(`EndRecord twice: expected ErrRecordNotOpen, got`, err)
+	}
+}
+
+// Records can be written to and read from an existing custom.Writer and custom.Reader, which are left open
+func TestRecordWrapped(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	w.WriteString8(`before`)
+	rw := NewRecordWriter(w)
+	rw.WriteRecord(func(w Interface) { w.WriteString8(`record`) })
+	rw.Close()
+	w.WriteString8(`after`)
+	w.Close()
+	r := NewReader(bytes.NewReader(b.Bytes()))
+	if r.ReadString8() != `before` {
+		t.Fatal(`read the wrong data before the record`)
+	}
+	rr := NewRecordReader(r, 0)
+	rec, err := rr.Next()
+	if err != nil || rec.ReadString8() != `record` {
+		t.Fatal(`read the wrong record`, err)
+	}
+	rr.Close()
+	if r.ReadString8() != `after` || r.EOF() != nil {
+		t.Fatal(`read the wrong data after the record`)
+	}
+}
@@ -0,0 +1,147 @@
+package custom
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"io"
+	"testing"
+)
+
+var resetTestDict = []byte(`a preset dictionary of the words which the test streams are made of`)
+
+// Writes a stream of n uint32s counting up from first
+func writeResetTestStream(w *Writer, first uint32, n int) {
+	for i := 0; i < n; i++ {
+		w.WriteUint32(first + uint32(i))
+	}
+}
+
+type resetTestFormat struct {
+	name string
+	write func(f io.Writer) *Writer
+	open func(f io.Reader) (*Reader, error)
+}
+
+var resetTestFormats = []resetTestFormat{
+	{`plain`, NewWriter, func(f io.Reader) (*Reader, error) { return NewReader(f), nil }},
+	{`zlib`, NewZlibWriter, OpenZlibReader},
+	{`snappy`, NewSnappyWriter, OpenSnappyReader},
+	{`zstd`, NewZstdWriter, OpenZstdReader},
+	{`gzip`, func(f io.Writer) *Writer { return NewWriterCloser(gzip.NewWriter(f)) }, OpenGzipReader},
+	{`zlib dict`, func(f io.Writer) *Writer { return NewZlibWriterDict(f, resetTestDict) }, func(f io.Reader) (*Reader, error) { return OpenZlibReaderDict(f, resetTestDict) }},
+	{`zstd dict`, func(f io.Writer) *Writer { return NewZstdWriterDict(f, resetTestDict) }, func(f io.Reader) (*Reader, error) { return OpenZstdReaderDict(f, resetTestDict) }},
+	{`parallel`, func(f io.Writer) *Writer { return NewParallelCompressedWriter(f, CodecZlib, 4096, 2) }, func(f io.Reader) (*Reader, error) { return OpenParallelReader(f, 2) }},
+	{`seekable`, func(f io.Writer) *Writer { return NewSeekableWriter(f, CodecSnappy, 4096) }, func(f io.Reader) (*Reader, error) { return OpenSeekableReader(f.(io.ReadSeeker)) }},
+	{`checksum`, func(f io.Writer) *Writer { return NewChecksumWriter(f, ChecksumCRC32C) }, OpenChecksumReader},
+	{`hashing`, func(f io.Writer) *Writer { return NewHashingWriter(f, sha256.New()) }, func(f io.Reader) (*Reader, error) { return NewHashingReader(f, sha256.New()), nil }},
+}
+
+func resetTestStream(t *testing.T, format resetTestFormat, first uint32, n int) []byte {
+	var b bytes.Buffer
+	w := format.write(&b)
+	writeResetTestStream(w, first, n)
+	if err := w.Close(); err != nil {
+		t.Fatal(format.name, err)
+	}
+	return b.Bytes()
+}
+
+func checkResetTestStream(t *testing.T, name string, r *Reader, first uint32, n int) {
+	for i := 0; i < n; i++ {
+		if v := r.ReadUint32(); v != first + uint32(i) {
+			t.Fatalf(`%s: value %d read as %d`, name, i, v)
+		}
+	}
+	if err := r.EOF(); err != nil {
+		t.Fatal(name, err)
+	}
+}
+
+// A Reader which has been closed can be Reset onto a second stream of the same format
+func TestResetAfterClose(t *testing.T) {
+	for _, format := range resetTestFormats {
+		one := resetTestStream(t, format, 0, 20000)
+		two := resetTestStream(t, format, 1000000, 30000)
+		r, err := format.open(bytes.NewReader(one))
+		if err != nil {
+			t.Fatal(format.name, err)
+		}
+		checkResetTestStream(t, format.name, r, 0, 20000)
+		if err = r.Close(); err != nil {
+			t.Fatal(format.name, err)
+		}
+		if err = r.Reset(bytes.NewReader(two)); err != nil {
+			t.Fatal(format.name, err)
+		}
+		checkResetTestStream(t, format.name + ` after Reset`, r, 1000000, 30000)
+		r.Close()
+	}
+}
+
+// A Reader can be Reset part way through a stream without being closed
+func TestResetMidStream(t *testing.T) {
+	for _, format := range resetTestFormats {
+		one := resetTestStream(t, format, 0, 20000)
+		two := resetTestStream(t, format, 7, 100)
+		r, err := format.open(bytes.NewReader(one))
+		if err != nil {
+			t.Fatal(format.name, err)
+		}
+		r.Readx(1000)
+		if err = r.Reset(bytes.NewReader(two)); err != nil {
+			t.Fatal(format.name, err)
+		}
+		checkResetTestStream(t, format.name, r, 7, 100)
+		r.Close()
+	}
+}
+
+func TestResetHashingSum(t *testing.T) {
+	var b bytes.Buffer
+	w := NewHashingWriter(&b, sha256.New())
+	writeResetTestStream(w, 0, 100)
+	w.Close()
+	r := NewHashingReader(bytes.NewReader(b.Bytes()), sha256.New())
+	io.ReadAll(r)
+	r.Close()
+	r.Reset(bytes.NewReader(b.Bytes()))
+	io.ReadAll(r)
+	if want := sha256.Sum256(b.Bytes()); !bytes.Equal(r.Sum(nil), want[:]) {
+		t.Fatal(`the hash after Reset includes the previous stream`)
+	}
+}
+
+func TestResetEncrypted(t *testing.T) {
+	data := encryptTestStream(t, CipherAES256GCM, CodecNone, 10)
+	r, err := NewEncryptedReader(bytes.NewReader(data), testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Reset(bytes.NewReader(data)); err != ErrResetUnsupported {
+		t.Fatal(`expected ErrResetUnsupported, got`, err)
+	}
+	r.Close()
+}
+
+// Reset onto a stream of the wrong format returns an error rather than reading the raw bytes
+func TestResetWrongFormat(t *testing.T) {
+	plain := resetTestStream(t, resetTestFormats[0], 0, 100)
+	for _, format := range resetTestFormats[1:] {
+		if format.name == `snappy` || format.name == `hashing` { // read lazily, or any bytes are valid
+			continue
+		}
+		r, err := format.open(bytes.NewReader(resetTestStream(t, format, 0, 10)))
+		if err != nil {
+			t.Fatal(format.name, err)
+		}
+		r.Close()
+		err = r.Reset(bytes.NewReader(plain))
+		if err == nil {
+			if e := catchPanic(func() { r.Readx(400) }); e == nil {
+				t.Fatal(format.name, `read a plain stream after Reset`)
+			}
+		}
+		r.Close()
+	}
+}
@@ -0,0 +1,127 @@
+package custom
+
+import (
+ "errors"
+ "io"
+)
+
+// Returned (or panicked, for methods without an error) when reading past the end of a section created with Limit
+var ErrLimitExceeded = errors.New(`Read past the end of the section`)
+
+// -------- SECTION READER --------
+
+// Reads up to the end of a section directly from the parent's underlying io.Reader
+type sectionReader struct {
+	parent *Reader
+	remaining int64
+}
+
+func (s *sectionReader) Read(p []byte) (int, error) {
+	if s.remaining <= 0 {
+		return 0, ErrLimitExceeded
+	}
+	if int64(len(p)) > s.remaining {
+		p = p[0:s.remaining]
+	}
+	if l := s.parent.limits; l != nil {
+		if err := l.total(s.parent.pos, 1); err != nil {
+			return 0, err
+		}
+		p = p[0:l.room(s.parent.pos, len(p))]
+	}
+	m, err := s.parent.f.Read(p)
+	s.parent.pos += int64(m)
+	s.remaining -= int64(m)
+	if err == io.EOF {
+		if s.remaining > 0 {
+			err = io.ErrUnexpectedEOF
+		} else {
+			err = nil
+		}
+	}
+	return m, err
+}
+
+// Skips what is left of the section, seeking if the parent's underlying io.Reader can
+func (s *sectionReader) skip(buf []byte) error {
+	if s.remaining <= 0 {
+		return nil
+	}
+	if sw, ok := s.parent.f.(io.Seeker); ok && !s.parent.close {
+		if _, err := sw.Seek(s.remaining, io.SeekCurrent); err == nil {
+			s.parent.pos += s.remaining
+			s.remaining = 0
+			return nil
+		}
+	}
+	for s.remaining > 0 {
+		if _, err := s.Read(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns a custom.Reader for the next n bytes. Reading past the end of the section panics with ErrLimitExceeded, except for Read which returns io.EOF, and EOF returns nil at the end of the section.
+// If the section is already in the buffer then it is copied, and both custom.Readers can be used in any order. Otherwise the section shares this custom.Reader's buffer and reads directly from its underlying io.Reader, so this custom.Reader must not be used again until the section has been read to its end or SkipRest has been called.
+// Closing the section does not close or skip anything, it only releases the section.
+// The section's Offset carries on from this custom.Reader's, in both cases, so Limits such as MaxTotalBytes apply to it at the same positions as they would to this custom.Reader.
+func (r *Reader) Limit(n int64) *Reader {
+	if n < 0 {
+		n = 0
+	}
+	if int64(r.n) >= n { // the whole section is in the buffer, which the parent will refill, so the section gets its own copy
+		end := r.at + int(n)
+		s := &Reader{buf: make([]byte, n), n: int(n), pos: r.Offset() + n, limits: r.limits, f: &sectionReader{parent: r}}
+		copy(s.buf, r.buf[r.at:end])
+		r.at = end
+		r.n -= int(n)
+		return s
+	}
+	s := &Reader{buf: r.buf, at: r.at, limits: r.limits, shared: true}
+	s.n = r.n
+	s.pos = r.pos
+	s.f = &sectionReader{parent: r, remaining: n - int64(r.n)}
+	r.at, r.n = 0, 0
+	return s
+}
+
+// Skips to the end of the stream, or to the end of the section for a custom.Reader created with Limit so that its parent can carry on after it
+func (r *Reader) SkipRest() error {
+	r.at, r.n = 0, 0
+	if s, ok := r.f.(*sectionReader); ok {
+		before := s.remaining
+		err := s.skip(r.buf)
+		r.pos += before - s.remaining
+		return err
+	}
+	for {
+		m, err := r.f.Read(r.buf)
+		r.pos += int64(m)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// -------- BYTES READER SECTION --------
+
+// Returns a BytesReader for the next n bytes, sharing the same slice of bytes, and moves this BytesReader past them. Reading past the end of the section panics rather than reading into what follows it.
+// Panics with io.ErrUnexpectedEOF if there are fewer than n bytes left.
+func (r *BytesReader) Slice(n int) *BytesReader {
+	end := r.cursor + n
+	if n < 0 || end > r.length {
+		panic(io.ErrUnexpectedEOF)
+	}
+	s := &BytesReader{data: r.data[r.cursor:end:end], length: n, limits: r.limits}
+	r.cursor = end
+	return s
+}
+
+// Moves the cursor to the end
+func (r *BytesReader) SkipRest() {
+	r.cursor = r.length
+}
@@ -0,0 +1,126 @@
+package custom
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// Returns n bytes where each is its position modulo 251, so that any misplaced read shows
+func sectionTestData(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+// A section that is already in the buffer must survive the parent refilling it
+func TestLimitInBufferInterleaved(t *testing.T) {
+	data := sectionTestData(4096)
+	r := NewReaderSize(bytes.NewReader(data), 1024)
+	r.Peek(1024)
+	s := r.Limit(100)
+	if got := r.Readx(2000); !bytes.Equal(got, data[100:2100]) {
+		t.Fatal(`parent read the wrong data after Limit`)
+	}
+	if got := r.ReadxRaw(900); !bytes.Equal(got, data[2100:3000]) {
+		t.Fatal(`parent read the wrong data after Readx`)
+	}
+	if got := s.Readx(100); !bytes.Equal(got, data[0:100]) {
+		t.Fatal(`section data was overwritten by the parent`)
+	}
+	if err := s.EOF(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if got := r.ReadxRaw(1096); !bytes.Equal(got, data[3000:]) {
+		t.Fatal(`parent read the wrong data at the end`)
+	}
+}
+
+func TestLimitInBuffer(t *testing.T) {
+	data := sectionTestData(300)
+	r := NewReader(bytes.NewReader(data))
+	r.Peek(300)
+	s := r.Limit(100)
+	if got := s.Peek(200); !bytes.Equal(got, data[0:100]) {
+		t.Fatal(`Peek beyond the section returned`, len(got), `bytes`)
+	}
+	if got, err := io.ReadAll(s); err != nil || !bytes.Equal(got, data[0:100]) {
+		t.Fatal(`section read`, len(got), `bytes, error`, err)
+	}
+	if e := catchPanic(func() { s.ReadByte() }); e != ErrLimitExceeded {
+		t.Fatal(`expected ErrLimitExceeded, got`, e)
+	}
+	if got := r.ReadxRaw(200); !bytes.Equal(got, data[100:]) {
+		t.Fatal(`parent read the wrong data after the section`)
+	}
+}
+
+func TestLimitBeyondBuffer(t *testing.T) {
+	data := sectionTestData(5000)
+	r := NewReaderSize(bytes.NewReader(data), 1024)
+	r.ReadByte()
+	s := r.Limit(3000)
+	if got := s.Readx(3000); !bytes.Equal(got, data[1:3001]) {
+		t.Fatal(`section read the wrong data`)
+	}
+	if err := s.EOF(); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Readx(1999); !bytes.Equal(got, data[3001:]) {
+		t.Fatal(`parent read the wrong data after the section`)
+	}
+}
+
+// A section counts its Offset, and so MaxTotalBytes, from the parent's position whether or not it was copied from the buffer
+func TestLimitSectionTotalBytes(t *testing.T) {
+	data := sectionTestData(300)
+	for _, n := range []int64{100, 200} {
+		r := NewReaderSize(bytes.NewReader(data), 1024)
+		r.SetLimits(Limits{MaxTotalBytes: 150})
+		r.Readx(10)
+		r.Peek(140)
+		s := r.Limit(n)
+		if s.Offset() != 10 {
+			t.Fatal(n, `section starts at offset`, s.Offset())
+		}
+		if got := s.Readx(100); !bytes.Equal(got, data[10:110]) {
+			t.Fatal(n, `section read the wrong data`)
+		}
+		if s.Offset() != 110 {
+			t.Fatal(n, `section is at offset`, s.Offset())
+		}
+		if n == 100 {
+			if e := catchPanic(func() { s.ReadByte() }); e != ErrLimitExceeded {
+				t.Fatal(`expected ErrLimitExceeded, got`, e)
+			}
+			if got := r.Readx(40); !bytes.Equal(got, data[110:150]) || r.Offset() != 150 {
+				t.Fatal(`parent read the wrong data after the section`)
+			}
+			if e := limitViolation(func() { r.ReadByte() }); e == nil || e.Limit != `MaxTotalBytes` {
+				t.Fatal(`expected a MaxTotalBytes violation, got`, e)
+			}
+			continue
+		}
+		if got := s.Readx(40); !bytes.Equal(got, data[110:150]) {
+			t.Fatal(`section read the wrong data up to the limit`)
+		}
+		if e := limitViolation(func() { s.ReadByte() }); e == nil || e.Limit != `MaxTotalBytes` {
+			t.Fatal(`expected a MaxTotalBytes violation, got`, e)
+		}
+		if e := limitViolation(func() { s.Readx(41) }); e == nil || e.Limit != `MaxTotalBytes` {
+			t.Fatal(`expected a MaxTotalBytes violation, got`, e)
+		}
+	}
+}
+
+// Returns what f panics with, or nil
+func catchPanic(f func()) (e interface{}) {
+	defer func() {
+		e = recover()
+	}()
+	f()
+	return
+}
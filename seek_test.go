@@ -0,0 +1,295 @@
+package custom
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+var _ io.WriteSeeker = (*Writer)(nil)
+var _ io.WriterAt = (*Writer)(nil)
+
+func seekTestFile(t *testing.T) *os.File {
+	f, err := os.CreateTemp(t.TempDir(), `seek`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// Fills in a pointer to a trailer once the trailer's position is known, both after the placeholder has been flushed and while it is still buffered
+func TestWriterWriteAt(t *testing.T) {
+	f := seekTestFile(t)
+	w := NewWriter(f)
+	w.WriteUint64(0) // a placeholder for the position of the trailer
+	writeResetTestStream(w, 0, 50000)
+	ptr := w.Offset()
+	w.WriteString(`TRAILER`)
+	var b [8]byte
+	for i := range b {
+		b[i] = byte(uint64(ptr) >> (8 * i))
+	}
+	if n, err := w.WriteAt(b[:], 0); err != nil || n != 8 {
+		t.Fatal(`WriteAt returned`, n, err)
+	}
+	if n, err := w.WriteAt([]byte(`Tr`), ptr); err != nil || n != 2 {
+		t.Fatal(`WriteAt in the buffer returned`, n, err)
+	}
+	if off, _ := w.Seek(0, io.SeekCurrent); off != ptr + 7 || w.Offset() != ptr + 7 {
+		t.Fatal(`WriteAt moved the position to`, off)
+	}
+	w.WriteString(`END`)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(f.Name())
+	r := NewBytesReader(data)
+	if int64(r.ReadUint64()) != ptr {
+		t.Fatal(`the pointer was not filled in`)
+	}
+	for i := 0; i < 50000; i++ {
+		if r.ReadUint32() != uint32(i) {
+			t.Fatal(`value`, i, `was overwritten`)
+		}
+	}
+	if s := string(r.ReadxRaw(10)); s != `TrAILEREND` || r.EOF() != nil {
+		t.Fatal(`the trailer is`, s)
+	}
+}
+
+// A write which is partly in the buffer and partly flushed goes to the file
+func TestWriterWriteAtStraddle(t *testing.T) {
+	f := seekTestFile(t)
+	w := NewWriter(f)
+	w.Write(bytes.Repeat([]byte(`a`), bufferLen + 100))
+	at := w.Offset() - 10
+	w.Flush()
+	w.Write(bytes.Repeat([]byte(`b`), 20))
+	if _, err := w.WriteAt(bytes.Repeat([]byte(`c`), 20), at); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	data, _ := os.ReadFile(f.Name())
+	if got := string(data[at:]); got != `cccccccccccccccccccc` + `bbbbbbbbbb` {
+		t.Fatal(`the end of the file is`, got)
+	}
+}
+
+func TestWriterSeek(t *testing.T) {
+	f := seekTestFile(t)
+	w := NewWriter(f)
+	w.WriteString(`0123456789`)
+	if off, err := w.Seek(2, io.SeekStart); err != nil || off != 2 {
+		t.Fatal(`Seek returned`, off, err)
+	}
+	w.WriteString(`ab`)
+	if off, err := w.Seek(-1, io.SeekEnd); err != nil || off != 9 || w.Offset() != 9 {
+		t.Fatal(`Seek returned`, off, err)
+	}
+	w.WriteString(`XYZ`)
+	if off, err := w.Seek(-8, io.SeekCurrent); err != nil || off != 4 {
+		t.Fatal(`Seek returned`, off, err)
+	}
+	w.WriteByte('-')
+	w.Close()
+	if data, _ := os.ReadFile(f.Name()); string(data) != `01ab-5678XYZ` {
+		t.Fatal(`the file is`, string(data))
+	}
+	if _, err := NewWriter(f).Seek(-1, io.SeekStart); err == nil {
+		t.Fatal(`seeked to a negative position`)
+	}
+}
+
+// Offsets are those of the file, even when the custom.Writer is created after something has been written to it
+func TestWriterSeekAfterHeader(t *testing.T) {
+	f := seekTestFile(t)
+	f.WriteString(`HEADER`)
+	w := NewWriter(f)
+	pos, err := w.Seek(0, io.SeekCurrent)
+	if err != nil || pos != 6 {
+		t.Fatal(`Seek returned`, pos, err)
+	}
+	w.WriteString(`PLACE`)
+	w.Write(bytes.Repeat([]byte(`x`), 3 * bufferLen))
+	if _, err = w.WriteAt([]byte(`HOLDR`), pos); err != nil {
+		t.Fatal(err)
+	}
+	if off := w.Offset(); off != 6 + 5 + 3 * int64(bufferLen) {
+		t.Fatal(`Offset is`, off)
+	}
+	w.Close()
+	if data, _ := os.ReadFile(f.Name()); string(data[0:11]) != `HEADERHOLDR` {
+		t.Fatal(`the file begins`, string(data[0:11]))
+	}
+}
+
+// Without an io.Seeker or io.WriterAt only the current position and the buffer can be used
+func TestWriterSeekUnsupported(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	w.WriteString(`abc`)
+	if off, err := w.Seek(0, io.SeekCurrent); err != nil || off != 3 {
+		t.Fatal(`Seek returned`, off, err)
+	}
+	if _, err := w.Seek(0, io.SeekStart); err == nil {
+		t.Fatal(`seeked on a bytes.Buffer`)
+	}
+	if _, err := w.WriteAt([]byte(`A`), 0); err != nil {
+		t.Fatal(`WriteAt in the buffer:`, err)
+	}
+	w.Flush()
+	if _, err := w.WriteAt([]byte(`B`), 0); err == nil {
+		t.Fatal(`WriteAt on a bytes.Buffer`)
+	}
+	w.Close()
+	if b.String() != `Abc` {
+		t.Fatal(`wrote`, b.String())
+	}
+	// a pipe cannot seek, so offsets are counted from where the custom.Writer started
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	w = NewWriter(pw)
+	w.WriteString(`abc`)
+	if off, err := w.Seek(0, io.SeekCurrent); err != nil || off != 3 {
+		t.Fatal(`Seek on a pipe returned`, off, err)
+	}
+	pw.Close()
+}
+
+// Counts the reads made of an io.ReadSeeker
+type countingReadSeeker struct {
+	io.ReadSeeker
+	reads int
+}
+
+func (c *countingReadSeeker) Read(p []byte) (int, error) {
+	c.reads++
+	return c.ReadSeeker.Read(p)
+}
+
+func TestReaderOffset(t *testing.T) {
+	b := NewBuffer(0)
+	b.WriteUint32(1)
+	b.WriteString8(`abc`)
+	b.WriteUint64Variable(300)
+	b.Write(sectionTestData(100000))
+	data := append([]byte(nil), b.Bytes()...)
+	r := NewReader(bytes.NewReader(data))
+	for _, c := range []struct {
+		read func()
+		want int64
+	}{
+		{func() {}, 0},
+		{func() { r.ReadUint32() }, 4},
+		{func() { r.ReadString8() }, 8},
+		{func() { r.ReadUint64Variable() }, 11},
+		{func() { r.Readx(bufferLen + 10) }, 21 + int64(bufferLen)},
+		{func() { r.Discard(1000) }, 1021 + int64(bufferLen)},
+		{func() { io.ReadAll(r) }, int64(len(data))},
+	} {
+		c.read()
+		if r.Offset() != c.want {
+			t.Fatalf(`Offset is %d, not %d`, r.Offset(), c.want)
+		}
+	}
+}
+
+func TestReaderSeek(t *testing.T) {
+	data := sectionTestData(300000)
+	f := &countingReadSeeker{ReadSeeker: bytes.NewReader(data)}
+	f.Seek(10, io.SeekStart)
+	r := NewReader(f)
+	r.ReadUint32()
+	if r.Offset() != 4 {
+		t.Fatal(`Offset before Seek is`, r.Offset())
+	}
+	check := func(want int64) {
+		t.Helper()
+		if r.Offset() != want {
+			t.Fatalf(`Offset is %d, not %d`, r.Offset(), want)
+		}
+		if c := r.ReadByte(); c != data[want] {
+			t.Fatalf(`read %d at %d, not %d`, c, want, data[want])
+		}
+	}
+	if pos, err := r.Seek(0, io.SeekCurrent); err != nil || pos != 14 {
+		t.Fatal(`Seek returned`, pos, err)
+	}
+	check(14)
+	reads := f.reads
+	r.Seek(-5, io.SeekCurrent)
+	check(10)
+	r.Seek(1000, io.SeekStart)
+	check(1000)
+	if f.reads != reads {
+		t.Fatal(`seeking within the buffer read from the underlying io.Reader`)
+	}
+	r.Seek(200000, io.SeekStart)
+	check(200000)
+	r.Seek(-1, io.SeekEnd)
+	check(299999)
+	r.Seek(3, io.SeekStart)
+	check(3)
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal(`seeked to a negative position`)
+	}
+	if _, err := r.Seek(0, 7); err == nil {
+		t.Fatal(`seeked with an invalid whence`)
+	}
+}
+
+// Without an io.Seeker a Reader can only seek within its buffer
+func TestReaderSeekUnsupported(t *testing.T) {
+	r := NewReader(io.MultiReader(bytes.NewReader([]byte(`abcdefgh`))))
+	r.Readx(3)
+	if _, err := r.Seek(-2, io.SeekCurrent); err != nil {
+		t.Fatal(err)
+	}
+	if r.ReadByte() != 'b' || r.Offset() != 2 {
+		t.Fatal(`read the wrong byte after Seek`)
+	}
+	if _, err := r.Seek(0, io.SeekEnd); err == nil {
+		t.Fatal(`SeekEnd without an io.Seeker`)
+	}
+}
+
+func TestBytesReaderSeek(t *testing.T) {
+	data := sectionTestData(1000)
+	r := NewBytesReader(data)
+	r.ReadUint32()
+	if r.Offset() != 4 {
+		t.Fatal(`Offset is`, r.Offset())
+	}
+	for _, c := range []struct {
+		offset int64
+		whence int
+		want int64
+	}{
+		{10, io.SeekStart, 10},
+		{-5, io.SeekCurrent, 6},
+		{-1, io.SeekEnd, 999},
+		{0, io.SeekStart, 0},
+	} {
+		if pos, err := r.Seek(c.offset, c.whence); err != nil || pos != c.want || r.Offset() != c.want {
+			t.Fatal(`Seek returned`, pos, err)
+		}
+		if r.ReadByte() != data[c.want] {
+			t.Fatal(`read the wrong byte at`, c.want)
+		}
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal(`seeked to a negative position`)
+	}
+	if _, err := r.Seek(0, 7); err == nil {
+		t.Fatal(`seeked with an invalid whence`)
+	}
+	r.Seek(2000, io.SeekStart)
+	if r.EOF() != nil {
+		t.Fatal(`not at EOF beyond the end`)
+	}
+}
@@ -0,0 +1,222 @@
+package custom
+
+import (
+ "errors"
+ "io"
+ "sort"
+)
+
+// A seekable stream is a block compressed stream in which the end of stream block is followed by an index.
+// The index is the uncompressed offset and the compressed offset (from the start of the stream) of each block as uint64s, followed by the total uncompressed length as a uint64, the number of blocks as a uint32 and then indexMagic.
+const (
+	indexMagic = "CIDX"
+	indexTrailerLen = 8 + 4 + len(indexMagic)
+)
+
+var ErrNoIndex = errors.New(`Block index not found`)
+
+// -------- SEEKABLE COMPRESSED WRITER --------
+
+// Creates a new buffered writer wrapping an io.Writer, which compresses in independent blocks of blockSize bytes (uncompressed) and finishes with an index of the blocks.
+// This allows NewSeekableReader to Seek to any uncompressed position by decompressing only the block that contains it. The stream can also be read sequentially with NewParallelReader.
+// Blocks are compressed on GOMAXPROCS goroutines. If blockSize <= 0 then 64 KiB is used; smaller blocks make seeking cheaper but compress less well.
+// The custom.Writer must be closed to write the index.
+func NewSeekableWriter(f io.Writer, codec Codec, blockSize int) *Writer {
+	return &Writer{w: newBlockWriter(f, codec, blockSize, 0, true), data: pool.Get().([]byte), close: true, under: f}
+}
+
+func writeBlockIndex(w io.Writer, index []int64, size int64) error {
+	b := NewBuffer(len(index) * 8 + indexTrailerLen)
+	defer b.Close()
+	for _, v := range index {
+		b.WriteUint64(uint64(v))
+	}
+	b.WriteUint64(uint64(size))
+	b.WriteUint32(uint32(len(index) / 2))
+	b.WriteString(indexMagic)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// -------- SEEKABLE COMPRESSED READER --------
+
+type seekableReader struct {
+	f io.ReadSeeker
+	codec Codec
+	blockSize int
+	base int64	// the position of the start of the stream in f
+	uoffsets, coffsets []int64
+	size int64	// the total uncompressed length
+	pos int64	// the uncompressed position of the next read
+	block int	// the index of the block in job.dst, or -1
+	job blockJob
+	header []byte
+}
+
+// Creates a new buffered reader wrapping an io.ReadSeeker which contains a stream written by NewSeekableWriter, beginning at the current position.
+// Seek on the custom.Reader then seeks to uncompressed positions. Panics if the stream or its index is invalid.
+func NewSeekableReader(f io.ReadSeeker) *Reader {
+	r, err := OpenSeekableReader(f)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Creates a new buffered reader wrapping an io.ReadSeeker which contains a stream written by NewSeekableWriter, beginning at the current position.
+// Seek on the custom.Reader then seeks to uncompressed positions. Returns an error if the stream or its index is invalid.
+func OpenSeekableReader(f io.ReadSeeker) (*Reader, error) {
+	sr, err := newSeekableReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{f: sr, buf: pool.Get().([]byte)}, nil
+}
+
+func newSeekableReader(f io.ReadSeeker) (*seekableReader, error) {
+	sr := &seekableReader{f: f, block: -1, header: make([]byte, 8)}
+	var err error
+	if sr.base, err = f.Seek(0, io.SeekCurrent); err != nil {
+		return nil, err
+	}
+	if sr.codec, sr.blockSize, err = readBlockHeader(f); err != nil {
+		return nil, err
+	}
+	if err = sr.readIndex(); err != nil {
+		return nil, err
+	}
+	return sr, nil
+}
+
+// Reads the header and index of the stream in f, which must be an io.ReadSeeker
+func (sr *seekableReader) reset(f io.Reader) (io.Reader, error) {
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		return nil, ErrResetUnsupported
+	}
+	nsr, err := newSeekableReader(rs)
+	if err != nil {
+		return nil, err
+	}
+	return nsr, nil
+}
+
+func (sr *seekableReader) readIndex() error {
+	end, err := sr.f.Seek(-int64(indexTrailerLen), io.SeekEnd)
+	if err != nil || end < sr.base {
+		return ErrNoIndex
+	}
+	trailer := make([]byte, indexTrailerLen)
+	if _, err = io.ReadFull(sr.f, trailer); err != nil {
+		return err
+	}
+	r := NewBytesReader(trailer)
+	sr.size = int64(r.ReadUint64())
+	count := int64(r.ReadUint32())
+	if string(r.ReadxRaw(len(indexMagic))) != indexMagic || sr.size < 0 {
+		return ErrNoIndex
+	}
+	start := end - count * 16
+	if start < sr.base {
+		return ErrNoIndex
+	}
+	if _, err = sr.f.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	index := make([]byte, count * 16)
+	if _, err = io.ReadFull(sr.f, index); err != nil {
+		return err
+	}
+	r = NewBytesReader(index)
+	sr.uoffsets = make([]int64, count)
+	sr.coffsets = make([]int64, count)
+	for i := range sr.uoffsets {
+		sr.uoffsets[i] = int64(r.ReadUint64())
+		sr.coffsets[i] = int64(r.ReadUint64())
+		if (i > 0 && sr.uoffsets[i] <= sr.uoffsets[i-1]) || sr.uoffsets[i] >= sr.size || sr.coffsets[i] < 0 || sr.coffsets[i] >= start - sr.base {
+			return ErrNoIndex
+		}
+	}
+	if count == 0 {
+		if sr.size != 0 {
+			return ErrNoIndex
+		}
+		return nil
+	}
+	if sr.uoffsets[0] != 0 {
+		return ErrNoIndex
+	}
+	last := count - 1 // the final block must end exactly at the uncompressed size
+	if _, err = sr.f.Seek(sr.base + sr.coffsets[last], io.SeekStart); err != nil {
+		return err
+	}
+	if _, err = io.ReadFull(sr.f, sr.header); err != nil {
+		return ErrNoIndex
+	}
+	ulen := int64(uint32(sr.header[4]) | uint32(sr.header[5])<<8 | uint32(sr.header[6])<<16 | uint32(sr.header[7])<<24)
+	if sr.uoffsets[last] + ulen != sr.size {
+		return ErrNoIndex
+	}
+	return nil
+}
+
+// Decompresses the block which contains the uncompressed position pos
+func (sr *seekableReader) load(pos int64) error {
+	i := sort.Search(len(sr.uoffsets), func(i int) bool { return sr.uoffsets[i] > pos }) - 1
+	if _, err := sr.f.Seek(sr.base + sr.coffsets[i], io.SeekStart); err != nil {
+		return err
+	}
+	sr.block = -1
+	clen, ulen, err := readBlock(sr.f, sr.header, &sr.job, sr.blockSize)
+	if err != nil {
+		return err
+	}
+	next := sr.size
+	if i + 1 < len(sr.uoffsets) {
+		next = sr.uoffsets[i+1]
+	}
+	if int64(ulen) != next - sr.uoffsets[i] {
+		return ErrCorruptBlock
+	}
+	if clen == ulen {
+		copy(sr.job.dst, sr.job.src) // stored uncompressed
+	} else if err = sr.codec.decompress(sr.job.dst, sr.job.src); err != nil {
+		return err
+	}
+	sr.block = i
+	return nil
+}
+
+func (sr *seekableReader) Read(p []byte) (int, error) {
+	if sr.pos >= sr.size {
+		return 0, io.EOF
+	}
+	if sr.block < 0 || sr.pos < sr.uoffsets[sr.block] || sr.pos >= sr.uoffsets[sr.block] + int64(len(sr.job.dst)) {
+		if err := sr.load(sr.pos); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, sr.job.dst[sr.pos - sr.uoffsets[sr.block]:])
+	sr.pos += int64(n)
+	return n, nil
+}
+
+// Sets the uncompressed position of the next Read. The block is not decompressed until it is read.
+func (sr *seekableReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+		case io.SeekStart:
+			abs = offset
+		case io.SeekCurrent:
+			abs = sr.pos + offset
+		case io.SeekEnd:
+			abs = sr.size + offset
+		default:
+			return 0, errors.New(`custom.Reader.Seek: invalid whence`)
+	}
+	if abs < 0 {
+		return 0, errors.New(`custom.Reader.Seek: negative position`)
+	}
+	sr.pos = abs
+	return abs, nil
+}
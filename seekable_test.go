@@ -0,0 +1,134 @@
+package custom
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// Writes n uint32s counting up from 0 after prefix, as a seekable stream
+func seekableTestStream(t *testing.T, codec Codec, prefix string, n int) []byte {
+	var b bytes.Buffer
+	b.WriteString(prefix)
+	w := NewSeekableWriter(&b, codec, 1000)
+	writeResetTestStream(w, 0, n)
+	if err := w.Close(); err != nil {
+		t.Fatal(codec, err)
+	}
+	return b.Bytes()
+}
+
+func TestSeekableRoundTrip(t *testing.T) {
+	for _, codec := range []Codec{CodecNone, CodecZlib, CodecSnappy, CodecZstd} {
+		data := seekableTestStream(t, codec, `prefix`, 100000)
+		f := bytes.NewReader(data)
+		f.Seek(6, io.SeekStart)
+		r, err := OpenSeekableReader(f)
+		if err != nil {
+			t.Fatal(codec, err)
+		}
+		for _, i := range []int{500, 3, 99999, 0, 25000, 25001, 249, 250} {
+			if _, err = r.Seek(int64(i * 4), io.SeekStart); err != nil {
+				t.Fatal(codec, err)
+			}
+			if v := r.ReadUint32(); v != uint32(i) {
+				t.Fatalf(`codec %d: value %d read as %d`, codec, i, v)
+			}
+		}
+		if _, err = r.Seek(-8, io.SeekEnd); err != nil {
+			t.Fatal(codec, err)
+		}
+		if v := r.ReadUint32(); v != 99998 {
+			t.Fatal(codec, `SeekEnd read`, v)
+		}
+		if _, err = r.Seek(-400, io.SeekCurrent); err != nil {
+			t.Fatal(codec, err)
+		}
+		if v := r.ReadUint32(); v != 99899 {
+			t.Fatal(codec, `SeekCurrent read`, v)
+		}
+		r.Seek(0, io.SeekStart)
+		checkResetTestStream(t, `seekable`, r, 0, 100000)
+		r.Close()
+		// the same stream can be read sequentially
+		f.Seek(6, io.SeekStart)
+		p, err := OpenParallelReader(f, 2)
+		if err != nil {
+			t.Fatal(codec, err)
+		}
+		checkResetTestStream(t, `parallel`, p, 0, 100000)
+		p.Close()
+	}
+}
+
+func TestSeekableEdges(t *testing.T) {
+	r, err := OpenSeekableReader(bytes.NewReader(seekableTestStream(t, CodecZlib, ``, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.EOF(); err != nil {
+		t.Fatal(`an empty stream:`, err)
+	}
+	r, err = OpenSeekableReader(bytes.NewReader(seekableTestStream(t, CodecZlib, ``, 1000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Seek(5000, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.EOF(); err != nil {
+		t.Fatal(`beyond the end:`, err)
+	}
+	if _, err = r.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal(`seeked to a negative position`)
+	}
+}
+
+func TestSeekableBadIndex(t *testing.T) {
+	good := seekableTestStream(t, CodecSnappy, ``, 2000)
+	n := len(good)
+	corrupt := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), good...))
+	}
+	for _, c := range []struct {
+		name string
+		data []byte
+	}{
+		{`size`, corrupt(func(b []byte) []byte { b[n - indexTrailerLen]++; return b })},
+		{`count`, corrupt(func(b []byte) []byte { b[n - indexTrailerLen + 8]++; return b })},
+		{`magic`, corrupt(func(b []byte) []byte { b[n - 1]++; return b })},
+		{`order`, corrupt(func(b []byte) []byte { b[n - indexTrailerLen - 16]++; return b })},
+		{`truncated`, good[0:n - 1]},
+		{`no index`, good[0:n - indexTrailerLen]},
+		{`empty`, nil},
+	} {
+		if _, err := OpenSeekableReader(bytes.NewReader(c.data)); err != ErrNoIndex && err != io.ErrUnexpectedEOF {
+			t.Fatalf(`%s: expected ErrNoIndex, got %v`, c.name, err)
+		}
+	}
+	// an index which claims 5 bytes with no blocks
+	b := NewBuffer(0)
+	b.WriteString("CBLK\x02\xe8\x03\x00\x00")
+	b.Write(make([]byte, 8))
+	b.WriteUint64(5)
+	b.WriteUint32(0)
+	b.WriteString(indexMagic)
+	if _, err := OpenSeekableReader(bytes.NewReader(b.Bytes())); err != ErrNoIndex {
+		t.Fatal(`expected ErrNoIndex, got`, err)
+	}
+}
+
+// A block which does not match the index is detected when it is read
+func TestSeekableCorruptBlock(t *testing.T) {
+	data := seekableTestStream(t, CodecNone, ``, 2000)
+	bad := append([]byte(nil), data...)
+	bad[blockStreamHeaderLen + 1008 + 4]-- // the second block's uncompressed length
+	r, err := OpenSeekableReader(bytes.NewReader(bad))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Seek(1500, io.SeekStart)
+	if e := catchPanic(func() { r.ReadUint32() }); e != ErrCorruptBlock {
+		t.Fatal(`expected ErrCorruptBlock, got`, e)
+	}
+}
@@ -0,0 +1,166 @@
+package custom
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Writes one of every type which has a Skip method, followed by a marker
+func skipTestData() []byte {
+	b := NewBuffer(0)
+	b.WriteString8(`abc`)
+	b.WriteString16(strings.Repeat(`x`, 300))
+	b.WriteString32(strings.Repeat(`y`, 100000))
+	b.WriteBytes8([]byte(`q`))
+	b.WriteBytes16(nil)
+	b.WriteBytes32([]byte(`zz`))
+	b.WriteUint16Variable(7)
+	b.WriteUint16Variable(4000)
+	b.WriteInt16Variable(-3)
+	b.WriteInt16Variable(20000)
+	b.WriteUint64Variable(0)
+	b.WriteUint64Variable(1 << 40)
+	b.Write2Uint64sVariable(5, 1 << 50)
+	b.WriteRune('a')
+	b.WriteRune('é')
+	b.WriteRune('€')
+	b.WriteUint32(0xdeadbeef)
+	return append([]byte(nil), b.Bytes()...)
+}
+
+// The Skip methods of Reader and BytesReader
+type skipper interface {
+	SkipString8()
+	SkipString16()
+	SkipString32()
+	SkipBytes8()
+	SkipBytes16()
+	SkipBytes32()
+	SkipUint16Variable()
+	SkipInt16Variable()
+	SkipUint64Variable()
+	Skip2Uint64sVariable()
+	SkipUTF8()
+	ReadUint32() uint32
+	Offset() int64
+}
+
+func skipAll(r skipper) {
+	r.SkipString8()
+	r.SkipString16()
+	r.SkipString32()
+	r.SkipBytes8()
+	r.SkipBytes16()
+	r.SkipBytes32()
+	r.SkipUint16Variable()
+	r.SkipUint16Variable()
+	r.SkipInt16Variable()
+	r.SkipInt16Variable()
+	r.SkipUint64Variable()
+	r.SkipUint64Variable()
+	r.Skip2Uint64sVariable()
+	r.SkipUTF8()
+	r.SkipUTF8()
+	r.SkipUTF8()
+}
+
+func TestSkip(t *testing.T) {
+	data := skipTestData()
+	f, err := os.CreateTemp(t.TempDir(), `skip`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write(data)
+	f.Seek(0, io.SeekStart)
+	for _, r := range []skipper{NewReader(io.MultiReader(bytes.NewReader(data))), NewReader(f), NewBytesReader(data)} {
+		skipAll(r)
+		if r.ReadUint32() != 0xdeadbeef || r.Offset() != int64(len(data)) {
+			t.Fatalf(`%T skipped to the wrong place`, r)
+		}
+	}
+}
+
+// A corrupt length cannot skip past the end
+func TestSkipPastEnd(t *testing.T) {
+	b := NewBuffer(0)
+	b.WriteString32(strings.Repeat(`a`, 200000))
+	data := append([]byte(nil), b.Bytes()...)
+	data[2]++ // the length is now 65536 more than there is
+	for _, r := range []skipper{NewReader(io.MultiReader(bytes.NewReader(data))), NewReader(bytes.NewReader(data)), NewBytesReader(data)} {
+		if e := catchPanic(func() { r.SkipString32() }); e != io.ErrUnexpectedEOF {
+			t.Fatalf(`%T: expected io.ErrUnexpectedEOF, got %v`, r, e)
+		}
+	}
+	if e := catchPanic(func() { NewBytesReader([]byte{3, 'a'}).SkipString8() }); e != io.ErrUnexpectedEOF {
+		t.Fatal(`expected io.ErrUnexpectedEOF, got`, e)
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	r := NewReader(io.MultiReader(bytes.NewReader(make([]byte, 300000))))
+	r.Discard(250000)
+	if r.Offset() != 250000 {
+		t.Fatal(`Offset after Discard is`, r.Offset())
+	}
+	if e := catchPanic(func() { r.Discard(100000) }); e != io.ErrUnexpectedEOF {
+		t.Fatal(`expected io.ErrUnexpectedEOF, got`, e)
+	}
+	r = NewReader(bytes.NewReader([]byte(`abc`)))
+	r.ReadByte()
+	if e := catchPanic(func() { r.Discard(-1) }); e == nil {
+		t.Fatal(`Discard(-1) did not panic`)
+	}
+	if r.ReadByte() != 'b' {
+		t.Fatal(`Discard(-1) moved backwards`)
+	}
+	r.Discard(0)
+	if r.ReadByte() != 'c' {
+		t.Fatal(`Discard(0) moved`)
+	}
+	br := NewBytesReader([]byte(`abc`))
+	br.ReadByte()
+	if e := catchPanic(func() { br.Discard(-1) }); e != io.ErrUnexpectedEOF {
+		t.Fatal(`expected io.ErrUnexpectedEOF, got`, e)
+	}
+	if e := catchPanic(func() { br.Discard(3) }); e != io.ErrUnexpectedEOF {
+		t.Fatal(`expected io.ErrUnexpectedEOF, got`, e)
+	}
+	br.Discard(2)
+	if br.EOF() != nil {
+		t.Fatal(`not at EOF after Discard`)
+	}
+}
+
+// Discarding more than the buffer holds seeks past the data, but not past the end of a file
+func TestDiscardSeek(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), `discard`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write(sectionTestData(200000))
+	f.Seek(100, io.SeekStart)
+	r := NewReader(f)
+	r.ReadByte()
+	r.Discard(150000)
+	if r.Offset() != 150001 || r.ReadByte() != sectionTestData(200000)[150101] {
+		t.Fatal(`Discard seeked to the wrong place`)
+	}
+	if e := catchPanic(func() { r.Discard(100000) }); e != io.ErrUnexpectedEOF {
+		t.Fatal(`expected io.ErrUnexpectedEOF, got`, e)
+	}
+	if err = r.EOF(); err != nil {
+		t.Fatal(`not at EOF after discarding past the end:`, err)
+	}
+	// Limits apply to what is seeked past
+	f.Seek(0, io.SeekStart)
+	r = NewReader(f)
+	r.SetLimits(Limits{MaxTotalBytes: 100000})
+	if e := limitViolation(func() { r.Discard(150000) }); e == nil || e.Limit != `MaxTotalBytes` {
+		t.Fatal(`expected a MaxTotalBytes violation, got`, e)
+	}
+}